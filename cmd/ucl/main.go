@@ -0,0 +1,458 @@
+// Command ucl reads, converts and checks UCL configuration files.
+//
+// Usage:
+//
+//	ucl convert [--to format] [-D name=value]... [file...]
+//	ucl get [-D name=value]... <path> [file...]
+//	ucl validate --schema file [-D name=value]... [file...]
+//	ucl fmt [-w] [-D name=value]... [file...]
+//	ucl vars [-D name=value]... [file...]
+//
+// Files are merged in the order they are given, and standard input is read
+// if there are none. fmt -w refuses to rewrite files with comments, macros
+// such as .include, or variables, which would be lost. The output formats
+// are json, json-compact, yaml, ucl and msgpack.
+//
+// The exit status is 0 on success, 1 if the input can't be parsed, the path
+// given to get doesn't exist or validation fails, and 2 for usage errors.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rjp/go-libucl"
+)
+
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+const usage = `usage: ucl <command> [flags] [file...]
+
+commands:
+  convert   convert the input to another format
+  get       print the value at a dotted path
+  validate  validate the input against a JSON schema
+  fmt       rewrite the input as canonical UCL
+  vars      print the input with variables expanded
+
+Run 'ucl <command> -h' for the flags of each command.
+`
+
+var formats = map[string]libucl.Emitter{
+	"json":         libucl.EmitJSON,
+	"json-compact": libucl.EmitJSONCompact,
+	"yaml":         libucl.EmitYAML,
+	"ucl":          libucl.EmitConfig,
+	"msgpack":      libucl.EmitMsgpack,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// command holds the state shared by all the subcommands.
+type command struct {
+	flags  *flag.FlagSet
+	vars   variables
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	name, args := args[0], args[1:]
+	c := &command{
+		flags:  flag.NewFlagSet("ucl "+name, flag.ContinueOnError),
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+	}
+	c.flags.SetOutput(stderr)
+	c.flags.Var(&c.vars, "D", "define a variable as `name=value`")
+
+	switch name {
+	case "convert":
+		return c.convert(args)
+	case "get":
+		return c.get(args)
+	case "validate":
+		return c.validate(args)
+	case "fmt":
+		return c.format(args)
+	case "vars":
+		return c.expandVars(args)
+	case "help", "-h", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "ucl: unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+}
+
+// parseFlags parses the flags of a subcommand. If it shouldn't go on, it
+// returns false with the status to exit with: -h asks for help, so that is
+// a success, as it is for the help command.
+func (c *command) parseFlags(args []string) (int, bool) {
+	err := c.flags.Parse(args)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK, false
+	case err != nil:
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+func (c *command) convert(args []string) int {
+	to := c.flags.String("to", "json", "output `format`: json, json-compact, yaml, ucl or msgpack")
+	if code, ok := c.parseFlags(args); !ok {
+		return code
+	}
+
+	emitter, ok := formats[*to]
+	if !ok {
+		fmt.Fprintf(c.stderr, "ucl: unknown format %q\n", *to)
+		return exitUsage
+	}
+
+	return c.emit(c.flags.Args(), emitter)
+}
+
+func (c *command) get(args []string) int {
+	if code, ok := c.parseFlags(args); !ok {
+		return code
+	}
+	if c.flags.NArg() < 1 {
+		fmt.Fprintln(c.stderr, "ucl get: missing path")
+		return exitUsage
+	}
+
+	path := c.flags.Arg(0)
+	obj, ok := c.parse(c.flags.Args()[1:])
+	if !ok {
+		return exitError
+	}
+	defer obj.Close()
+
+	value := obj.Lookup(path)
+	if value == nil {
+		fmt.Fprintf(c.stderr, "ucl get: %s: not found\n", path)
+		return exitError
+	}
+	defer value.Close()
+
+	// Strings are printed raw so they can be used directly by scripts
+	if value.Type() == libucl.ObjectTypeString {
+		fmt.Fprintln(c.stdout, value.ToString())
+		return exitOK
+	}
+
+	result, err := value.Emit(libucl.EmitJSON)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "ucl get: %s\n", err)
+		return exitError
+	}
+	fmt.Fprintln(c.stdout, strings.TrimRight(result, "\n"))
+	return exitOK
+}
+
+func (c *command) validate(args []string) int {
+	schemaPath := c.flags.String("schema", "", "JSON schema `file` to validate against")
+	if code, ok := c.parseFlags(args); !ok {
+		return code
+	}
+	if *schemaPath == "" {
+		fmt.Fprintln(c.stderr, "ucl validate: missing --schema")
+		return exitUsage
+	}
+
+	p := libucl.NewParser(0)
+	defer p.Close()
+	if err := p.AddFile(*schemaPath); err != nil {
+		c.report(*schemaPath, err)
+		return exitError
+	}
+	schema := p.Object()
+	if schema == nil {
+		fmt.Fprintf(c.stderr, "%s: empty schema\n", *schemaPath)
+		return exitError
+	}
+	defer schema.Close()
+
+	obj, ok := c.parse(c.flags.Args())
+	if !ok {
+		return exitError
+	}
+	defer obj.Close()
+
+	err := obj.ValidateAll(schema)
+	if err == nil {
+		return exitOK
+	}
+
+	name := strings.Join(c.flags.Args(), ",")
+	if name == "" {
+		name = "<stdin>"
+	}
+
+	var errs libucl.SchemaErrors
+	if !errors.As(err, &errs) {
+		fmt.Fprintf(c.stderr, "%s: %s\n", name, err)
+		return exitError
+	}
+	for _, e := range errs {
+		path := e.Path()
+		if path == "" {
+			path = "/"
+		}
+		fmt.Fprintf(c.stderr, "%s: %s: %s\n", name, path, e.Message())
+	}
+	return exitError
+}
+
+func (c *command) format(args []string) int {
+	write := c.flags.Bool("w", false, "write the result back to each file instead of standard output")
+	if code, ok := c.parseFlags(args); !ok {
+		return code
+	}
+
+	if !*write {
+		return c.emit(c.flags.Args(), libucl.EmitConfig)
+	}
+
+	if c.flags.NArg() == 0 {
+		fmt.Fprintln(c.stderr, "ucl fmt: -w needs at least one file")
+		return exitUsage
+	}
+
+	status := exitOK
+	for _, path := range c.flags.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %s\n", path, err)
+			status = exitError
+			continue
+		}
+		if lost := rewriteLoses(data); lost != "" {
+			fmt.Fprintf(c.stderr, "%s: not rewriting, %s would be lost\n", path, lost)
+			status = exitError
+			continue
+		}
+
+		obj, ok := c.parse([]string{path})
+		if !ok {
+			status = exitError
+			continue
+		}
+
+		result, err := obj.Emit(libucl.EmitConfig)
+		obj.Close()
+		if err == nil {
+			err = writeFile(path, []byte(result))
+		}
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %s\n", path, err)
+			status = exitError
+		}
+	}
+	return status
+}
+
+func (c *command) expandVars(args []string) int {
+	if code, ok := c.parseFlags(args); !ok {
+		return code
+	}
+
+	return c.emit(c.flags.Args(), libucl.EmitConfig)
+}
+
+// emit parses the given files and writes them to standard output.
+func (c *command) emit(paths []string, t libucl.Emitter) int {
+	obj, ok := c.parse(paths)
+	if !ok {
+		return exitError
+	}
+	defer obj.Close()
+
+	if err := obj.EmitTo(c.stdout, t); err != nil {
+		fmt.Fprintf(c.stderr, "ucl: %s\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// parse merges the given files, or standard input if there are none, with
+// the variables from -D. Any errors are reported on standard error.
+func (c *command) parse(paths []string) (*libucl.Object, bool) {
+	p := libucl.NewParser(0)
+	defer p.Close()
+
+	for _, v := range c.vars {
+		p.RegisterVariable(v.name, v.value)
+	}
+
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+
+	for _, path := range paths {
+		var err error
+		if path == "-" {
+			path = "<stdin>"
+			err = p.AddReader(c.stdin)
+		} else {
+			err = p.AddFile(path)
+		}
+
+		if err != nil {
+			c.report(path, err)
+			return nil, false
+		}
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		obj = libucl.NewObjectMap()
+	}
+	return obj, true
+}
+
+// report writes a parse error as file:line:column, followed by the
+// offending line if it can be found.
+func (c *command) report(path string, err error) {
+	var perr *libucl.ParseError
+	if !errors.As(err, &perr) {
+		fmt.Fprintf(c.stderr, "%s: %s\n", path, err)
+		return
+	}
+
+	if perr.Source != "" {
+		path = perr.Source
+	}
+
+	location := path
+	if perr.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, perr.Line)
+		if perr.Column > 0 {
+			location = fmt.Sprintf("%s:%d", location, perr.Column)
+		}
+	}
+
+	fmt.Fprintf(c.stderr, "%s: %s\n", location, perr.Message)
+	if snippet := perr.Snippet(); snippet != "" {
+		fmt.Fprintln(c.stderr, snippet)
+	}
+}
+
+// rewriteLoses returns what rewriting data as canonical UCL would lose, or
+// the empty string if nothing would be. The emitter drops comments, and
+// writes the result of macros and variables rather than the directives
+// themselves. It errs on the side of caution.
+func rewriteLoses(data []byte) string {
+	// At the start of a statement, where a macro can be
+	start := true
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '$':
+			return "variables"
+		case c == '#', c == '/' && i+1 < len(data) && data[i+1] == '*':
+			return "comments"
+		case c == '.' && start && i+1 < len(data) && isLetter(data[i+1]):
+			return "macros"
+		case c == '"' || c == '\'':
+			// Skip the string, apart from variables
+			for i++; i < len(data) && data[i] != c; i++ {
+				if data[i] == '$' {
+					return "variables"
+				}
+				if data[i] == '\\' {
+					i++
+				}
+			}
+		case c == '<' && i+1 < len(data) && data[i+1] == '<':
+			// Skip a heredoc, which ends at a line holding its terminator
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return ""
+			}
+			term := "\n" + string(bytes.TrimSpace(data[i+2:i+end])) + "\n"
+			rest := data[i+end:]
+			body := rest
+			if j := bytes.Index(rest, []byte(term)); j >= 0 {
+				body = rest[:j]
+			}
+			if bytes.IndexByte(body, '$') >= 0 {
+				return "variables"
+			}
+			i += end + len(body)
+		}
+
+		switch c {
+		case '\n', ';', '{', '}':
+			start = true
+		case ' ', '\t', '\r':
+		default:
+			start = false
+		}
+	}
+
+	return ""
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// writeFile replaces the contents of path, keeping its permissions.
+func writeFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, info.Mode().Perm())
+}
+
+type variable struct {
+	name  string
+	value string
+}
+
+// variables is a flag.Value collecting repeated -D name=value flags.
+type variables []variable
+
+func (v *variables) String() string {
+	parts := make([]string, len(*v))
+	for i, variable := range *v {
+		parts[i] = variable.name + "=" + variable.value
+	}
+	return strings.Join(parts, " ")
+}
+
+func (v *variables) Set(s string) error {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected name=value, got %q", s)
+	}
+
+	*v = append(*v, variable{name: parts[0], value: parts[1]})
+	return nil
+}
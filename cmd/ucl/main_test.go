@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "ucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	return path
+}
+
+func testRun(t *testing.T, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun_usage(t *testing.T) {
+	if code, _, _ := testRun(t, ""); code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+	if code, _, _ := testRun(t, "", "bogus"); code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+	if code, _, _ := testRun(t, "", "convert", "--to", "xml"); code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+	if code, _, _ := testRun(t, "", "validate"); code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestRun_help(t *testing.T) {
+	cases := [][]string{
+		{"help"},
+		{"-h"},
+		{"convert", "-h"},
+		{"get", "-h"},
+		{"validate", "-h"},
+		{"fmt", "-h"},
+		{"vars", "-h"},
+	}
+
+	for _, args := range cases {
+		if code, _, _ := testRun(t, "", args...); code != exitOK {
+			t.Fatalf("%v: bad: %d", args, code)
+		}
+	}
+}
+
+func TestRun_convert(t *testing.T) {
+	code, stdout, stderr := testRun(t, `foo = bar;`, "convert", "--to", "json-compact")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if stdout != `{"foo":"bar"}` {
+		t.Fatalf("bad: %q", stdout)
+	}
+}
+
+func TestRun_parseError(t *testing.T) {
+	path := testFile(t, "bad.conf", "foo = bar;\nbaz = {\n")
+
+	code, _, stderr := testRun(t, "", "convert", path)
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.HasPrefix(stderr, path+":") {
+		t.Fatalf("bad: %q", stderr)
+	}
+}
+
+func TestRun_get(t *testing.T) {
+	input := `server { name = "example"; port = 80; }`
+
+	code, stdout, _ := testRun(t, input, "get", "server.name")
+	if code != exitOK || stdout != "example\n" {
+		t.Fatalf("bad: %d %q", code, stdout)
+	}
+
+	code, stdout, _ = testRun(t, input, "get", "server.port")
+	if code != exitOK || stdout != "80\n" {
+		t.Fatalf("bad: %d %q", code, stdout)
+	}
+
+	code, _, _ = testRun(t, input, "get", "server.missing")
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+}
+
+func TestRun_validate(t *testing.T) {
+	schema := testFile(t, "schema.json", `{
+		"type": "object",
+		"properties": { "port": { "type": "integer" } },
+		"required": ["port"]
+	}`)
+
+	code, _, stderr := testRun(t, `port = 80;`, "validate", "--schema", schema)
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	code, _, stderr = testRun(t, `port = "http";`, "validate", "--schema", schema)
+	if code != exitError {
+		t.Fatalf("bad: %d", code)
+	}
+	if !strings.Contains(stderr, "/port") {
+		t.Fatalf("bad: %q", stderr)
+	}
+}
+
+func TestRun_fmtWrite(t *testing.T) {
+	path := testFile(t, "app.conf", `foo="bar"`)
+
+	if code, _, stderr := testRun(t, "", "fmt", "-w", path); code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !strings.Contains(string(data), `foo = "bar";`) {
+		t.Fatalf("bad: %q", data)
+	}
+}
+
+func TestRun_fmtWriteLossy(t *testing.T) {
+	inputs := map[string]string{
+		"comment":  "# the name\nfoo = \"bar\";\n",
+		"block":    "foo = \"bar\"; /* the name */\n",
+		"include":  "foo = \"bar\";\n.include \"other.conf\"\n",
+		"variable": "foo = \"$HOST\";\n",
+		"heredoc":  "foo = <<EOD\n${HOST}\nEOD\n",
+	}
+
+	for name, input := range inputs {
+		path := testFile(t, "app.conf", input)
+
+		if code, _, stderr := testRun(t, "", "fmt", "-w", path); code != exitError || stderr == "" {
+			t.Fatalf("%s: bad: %d %s", name, code, stderr)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if string(data) != input {
+			t.Fatalf("%s: bad: %q", name, data)
+		}
+	}
+}
+
+func TestRewriteLoses(t *testing.T) {
+	inputs := []string{
+		`foo = "bar # not a comment";`,
+		`foo = 'a.b'; bar = 1.5;`,
+		"foo = <<EOD\n# kept\nEOD\nbar = 1;\n",
+		`path = "/etc/*.conf";`,
+	}
+
+	for _, input := range inputs {
+		if lost := rewriteLoses([]byte(input)); lost != "" {
+			t.Fatalf("%s: bad: %s", input, lost)
+		}
+	}
+}
+
+func TestRun_vars(t *testing.T) {
+	code, stdout, stderr := testRun(t, `host = "$HOST";`, "vars", "-D", "HOST=example.com")
+	if code != exitOK {
+		t.Fatalf("bad: %d %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "example.com") {
+		t.Fatalf("bad: %q", stdout)
+	}
+
+	if code, _, _ := testRun(t, "", "vars", "-D", "HOST"); code != exitUsage {
+		t.Fatalf("bad: %d", code)
+	}
+}
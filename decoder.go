@@ -1,60 +1,414 @@
 package libucl
 
 import (
+	"encoding"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const tagName = "libucl"
 
+// tagOptions returns the options of a split struct tag, which follow the
+// name. A `default` option takes the rest of the tag, so it isn't included.
+func tagOptions(tagParts []string) []string {
+	for i, option := range tagParts[1:] {
+		if strings.HasPrefix(option, "default=") {
+			return tagParts[1 : i+1]
+		}
+	}
+	return tagParts[1:]
+}
+
+// tagDefault returns the value of the `default` option of a split struct
+// tag. The option must come last, so that the value can contain commas.
+func tagDefault(tagParts []string) (string, bool) {
+	for i, option := range tagParts[1:] {
+		if strings.HasPrefix(option, "default=") {
+			value := strings.Join(tagParts[i+1:], ",")
+			return strings.TrimPrefix(value, "default="), true
+		}
+	}
+	return "", false
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	timeType            = reflect.TypeOf(time.Time{})
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Unmarshaler is the interface implemented by types that can decode
+// themselves from a libucl object.
+type Unmarshaler interface {
+	UnmarshalUCL(*Object) error
+}
+
+// Defaulter is the interface implemented by types that set their own
+// default values. SetDefaults is called before anything is decoded into
+// the value.
+type Defaulter interface {
+	SetDefaults()
+}
+
+// DecodeHook is the callback type for custom conversions in a Decoder, set
+// with DecoderConfig.DecodeHooks. It is called with the type of the object
+// being decoded, the type of the Go value it is being decoded into, and the
+// object itself.
+//
+// To decode the object, the hook returns a value that can be assigned to
+// the Go type. Returning a nil value leaves the object to the next hook, or
+// the normal decoding rules.
+type DecodeHook func(from ObjectType, to reflect.Type, o *Object) (interface{}, error)
+
+// DecoderConfig is the configuration that is used to create a new decoder
+// and allows customization of various aspects of decoding.
+type DecoderConfig struct {
+	// Result is a pointer to the value to decode into.
+	Result interface{}
+
+	// ErrorUnused makes decoding fail if the object has keys that don't
+	// match any field of the struct they are decoded into. Every unknown
+	// key is named in the error with its full path, such as
+	// "server.tls.crt". Structs with an `unusedKeys` field accept any key.
+	ErrorUnused bool
+
+	// CaseSensitive disables the case-insensitive match that is tried
+	// when no key matches the name of a field exactly.
+	CaseSensitive bool
+
+	// PlainMaps makes objects decoded into an interface{} plain
+	// map[string]interface{} values, rather than a []map[string]interface{}
+	// holding each object for the key. A key only becomes a []interface{}
+	// if it is repeated.
+	PlainMaps bool
+
+	// ExpandEnv replaces ${VAR} references in string values with the value
+	// of the variable, or the empty string if it isn't set. This is done
+	// as values are decoded, unlike the variables of a Parser.
+	ExpandEnv bool
+
+	// LookupEnv looks up variables for ExpandEnv and the `env` option of
+	// fields. If it is nil, os.LookupEnv is used.
+	LookupEnv func(name string) (string, bool)
+
+	// DecodeHooks are tried in order for every value that is decoded,
+	// before Unmarshaler and encoding.TextUnmarshaler.
+	DecodeHooks []DecodeHook
+}
+
+// Decoder decodes libucl objects into native Go structures, as configured
+// by a DecoderConfig.
+type Decoder struct {
+	config *DecoderConfig
+
+	// The full paths of the keys that weren't decoded, for ErrorUnused
+	unused []string
+
+	// Every failure so far, so that one bad value doesn't hide the rest
+	errors []*FieldError
+}
+
+// DecodeError is the error returned by Decode when any value can't be
+// decoded. Decoding carries on past a bad value, so it holds every failure
+// in the object rather than just the first.
+type DecodeError struct {
+	Errors []*FieldError
+}
+
+// Error returns every error message on its own line.
+func (e *DecodeError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns every error, for use with errors.Is and errors.As.
+func (e *DecodeError) Unwrap() []error {
+	result := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		result[i] = err
+	}
+	return result
+}
+
+// FieldError is a single failure found while decoding.
+type FieldError struct {
+	// Path is the full path of the value, such as "servers[2].listen.port",
+	// or the empty string for errors about the object as a whole. Keys are
+	// named as they are in the object and joined with dots whether they
+	// are decoded into structs, maps or interfaces, and array items are
+	// indexed in brackets. A missing key is named by its field's tag, or
+	// the field name if it has none.
+	//
+	// There is no line number, as libucl doesn't keep the position of
+	// values once they are parsed. The path is what locates the value.
+	Path string
+	// Expected is the Go type the value was being decoded into, or nil if
+	// the error isn't about a single value.
+	Expected reflect.Type
+	// Actual is the type of the value.
+	Actual ObjectType
+	// Err is what went wrong.
+	Err error
+}
+
+// Error returns the message of the error, prefixed with its path.
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldErrorf builds the error for the value o at the path name, which
+// was being decoded into a value of type t.
+func fieldErrorf(name string, o *Object, t reflect.Type, format string, args ...interface{}) error {
+	return &FieldError{
+		Path:     name,
+		Expected: t,
+		Actual:   o.Type(),
+		Err:      fmt.Errorf(format, args...),
+	}
+}
+
+// parseErrorf builds the error for a string s that can't be parsed as a
+// value of type t.
+func parseErrorf(name string, o *Object, t reflect.Type, s string, err error) error {
+	// The value is already in the message
+	var nerr *strconv.NumError
+	if errors.As(err, &nerr) {
+		err = nerr.Err
+	}
+	return fieldErrorf(name, o, t, "cannot parse %q as %s: %w", s, t, err)
+}
+
+// collect records a failure and lets decoding carry on with the next value.
+func (d *Decoder) collect(err error) {
+	ferr, ok := err.(*FieldError)
+	if !ok {
+		ferr = &FieldError{Err: err}
+	}
+	d.errors = append(d.errors, ferr)
+}
+
+// NewDecoder returns a new decoder for the given configuration.
+func NewDecoder(config *DecoderConfig) (*Decoder, error) {
+	val := reflect.ValueOf(config.Result)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return nil, errors.New("result must be a non-nil pointer")
+	}
+
+	return &Decoder{config: config}, nil
+}
+
 // Decode decodes a libucl object into a native Go structure.
+//
+// Byte slices are decoded from the raw bytes of a string, or from base64
+// if the field is tagged with the `base64` option, as in
+// `libucl:"key,base64"`. Fields tagged with the `required` option must have
+// a matching key.
+//
+// Fields whose key is missing can be given a default value with the
+// `default` option, which must be the last one in the tag, as in
+// `libucl:"port,default=8080"`. The value is parsed in the same way as
+// NewFormattedObject with StringParse, except for strings and byte slices,
+// which take it as it is. Defaults are set before anything is decoded into
+// a value: SetDefaults is called first for types that implement Defaulter,
+// then the `default` options of a struct's fields are applied, and then
+// those of any nested structs. A `default` option only sets a field that is
+// still the zero value, so it never replaces a value already in the result
+// or set by SetDefaults. The fields of types that decode themselves, and of
+// time.Time, are left alone.
+//
+// The `env` option names an environment variable that overrides the value
+// of the field when it is set, as in `libucl:"port,env=PORT"`. The value of
+// the variable is parsed in the same way as a default.
+//
+// If anything can't be decoded, the error is a *DecodeError listing every
+// failure with its path.
+//
+// Types that implement Unmarshaler decode themselves, as do types that
+// implement encoding.TextUnmarshaler when the object is a string. Use
+// NewDecoder with DecoderConfig.DecodeHooks to decode other types. A *Object
+// is set to the object itself, with every value of a repeated key, and must
+// be closed.
 func (o *Object) Decode(v interface{}) error {
-	return decode("", o, reflect.ValueOf(v).Elem())
+	d, err := NewDecoder(&DecoderConfig{Result: v})
+	if err != nil {
+		return err
+	}
+
+	return d.Decode(o)
+}
+
+// DecodeGeneric decodes the object into plain Go values: objects become
+// map[string]interface{}, arrays and repeated keys []interface{}, and
+// scalars bool, int, float64, string, time.Duration or nil. See
+// DecoderConfig.PlainMaps.
+func (o *Object) DecodeGeneric() (interface{}, error) {
+	var result interface{}
+	d, err := NewDecoder(&DecoderConfig{Result: &result, PlainMaps: true})
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.Decode(o)
+	return result, err
 }
 
-func decode(name string, o *Object, result reflect.Value) error {
+// Decode decodes the object into the result of the decoder's configuration,
+// in the same way as Object.Decode.
+func (d *Decoder) Decode(o *Object) error {
+	d.unused = nil
+	d.errors = nil
+
+	result := reflect.ValueOf(d.config.Result).Elem()
+	d.decodeDefaults("", result)
+	if err := d.decode("", o, result); err != nil {
+		d.collect(err)
+	}
+
+	if len(d.unused) > 0 {
+		// Repeated objects can have the same unknown keys
+		sort.Strings(d.unused)
+		keys := d.unused[:1]
+		for _, k := range d.unused[1:] {
+			if k != keys[len(keys)-1] {
+				keys = append(keys, k)
+			}
+		}
+
+		d.collect(fmt.Errorf("unknown keys: %s", strings.Join(keys, ", ")))
+	}
+
+	if len(d.errors) > 0 {
+		return &DecodeError{Errors: d.errors}
+	}
+	return nil
+}
+
+func (d *Decoder) decode(name string, o *Object, result reflect.Value) error {
+	if ok, err := d.decodeWithHooks(name, o, result); ok || err != nil {
+		return err
+	}
+
+	if result.CanAddr() {
+		ptr := result.Addr()
+		if ptr.Type().Implements(unmarshalerType) {
+			if err := ptr.Interface().(Unmarshaler).UnmarshalUCL(o); err != nil {
+				return fieldErrorf(name, o, result.Type(), "%w", err)
+			}
+			return nil
+		}
+	}
+
+	switch result.Type() {
+	case objectPtrType:
+		// Increase the ref count
+		o.Ref()
+
+		// Set the object, with its own wrapper so it can be closed
+		// independently of the one we're decoding
+		result.Set(reflect.ValueOf(newObject(o.object)))
+		return nil
+	case durationType:
+		return d.decodeIntoDuration(name, o, result)
+	case timeType:
+		return d.decodeIntoTime(name, o, result)
+	}
+
+	if o.Type() == ObjectTypeString && result.CanAddr() {
+		ptr := result.Addr()
+		if ptr.Type().Implements(textUnmarshalerType) {
+			text := d.stringValue(o)
+			if err := ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); err != nil {
+				return parseErrorf(name, o, result.Type(), text, err)
+			}
+			return nil
+		}
+	}
+
 	switch result.Kind() {
 	case reflect.Bool:
-		return decodeIntoBool(name, o, result)
+		return d.decodeIntoBool(name, o, result)
 	case reflect.Interface:
 		// Interface is a bit weird. When we see an interface, we do
 		// our best effort to determine the type, and put it into that.
-		return decodeIntoInterface(name, o, result)
-	case reflect.Int:
-		return decodeIntoInt(name, o, result)
-	case reflect.Int64:
-		return decodeIntoInt(name, o, result)
-	case reflect.Uint:
-		return decodeIntoUint(name, o, result)
-	case reflect.Uint64:
-		return decodeIntoUint(name, o, result)
+		return d.decodeIntoInterface(name, o, result)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return d.decodeIntoInt(name, o, result)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return d.decodeIntoUint(name, o, result)
+	case reflect.Float32, reflect.Float64:
+		return d.decodeIntoFloat(name, o, result)
+	case reflect.Array:
+		return d.decodeIntoArray(name, o, result)
 	case reflect.Map:
-		return decodeIntoMap(name, o, result)
+		return d.decodeIntoMap(name, o, result)
 	case reflect.Ptr:
-		return decodeIntoPtr(name, o, result)
+		return d.decodeIntoPtr(name, o, result)
 	case reflect.Slice:
-		return decodeIntoSlice(name, o, result)
+		if result.Type().Elem().Kind() == reflect.Uint8 && o.Type() == ObjectTypeString {
+			return d.decodeIntoBytes(name, o, result, false)
+		}
+		return d.decodeIntoSlice(name, o, result)
 	case reflect.String:
-		return decodeIntoString(name, o, result)
+		return d.decodeIntoString(name, o, result)
 	case reflect.Struct:
-		return decodeIntoStruct(name, o, result)
-	case reflect.Float64:
-		return decodeIntoFloat64(name, o, result)
+		return d.decodeIntoStruct(name, o, result)
 	default:
-		return fmt.Errorf("%s: unsupported type: %s", name, result.Kind())
+		return fieldErrorf(name, o, result.Type(), "unsupported type: %s", result.Kind())
 	}
 }
 
-func decodeIntoBool(name string, o *Object, result reflect.Value) error {
+// decodeWithHooks tries the configured hooks in order, and reports whether
+// one of them decoded the object.
+func (d *Decoder) decodeWithHooks(name string, o *Object, result reflect.Value) (bool, error) {
+	for _, h := range d.config.DecodeHooks {
+		v, err := h(o.Type(), result.Type(), o)
+		if err != nil {
+			return false, fieldErrorf(name, o, result.Type(), "%w", err)
+		}
+		if v == nil {
+			continue
+		}
+
+		val := reflect.ValueOf(v)
+		if !val.Type().AssignableTo(result.Type()) {
+			return false, fieldErrorf(name, o, result.Type(),
+				"decode hook returned %s, expected %s", val.Type(), result.Type())
+		}
+		result.Set(val)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (d *Decoder) decodeIntoBool(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
 	case ObjectTypeString:
-		b, err := strconv.ParseBool(o.ToString())
+		s := d.stringValue(o)
+		b, err := strconv.ParseBool(s)
 		if err == nil {
 			result.SetBool(b)
 		} else {
-			return fmt.Errorf("cannot parse '%s' as bool: %s", name, err)
+			return parseErrorf(name, o, result.Type(), s, err)
 		}
 	default:
 		result.SetBool(o.ToBool())
@@ -63,55 +417,140 @@ func decodeIntoBool(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoInt(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoInt(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
 	case ObjectTypeString:
-		i, err := strconv.ParseInt(o.ToString(), 0, result.Type().Bits())
+		s := d.stringValue(o)
+		i, err := strconv.ParseInt(s, 0, result.Type().Bits())
 		if err == nil {
 			result.SetInt(i)
 		} else {
-			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
+			return parseErrorf(name, o, result.Type(), s, err)
 		}
 	default:
-		result.SetInt(o.ToInt())
+		i := o.ToInt()
+		if result.OverflowInt(i) {
+			return fieldErrorf(name, o, result.Type(), "value %d overflows %s", i, result.Type())
+		}
+		result.SetInt(i)
 	}
 
 	return nil
 }
 
-func decodeIntoUint(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoUint(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
 	case ObjectTypeString:
-		i, err := strconv.ParseUint(o.ToString(), 0, result.Type().Bits())
+		s := d.stringValue(o)
+		i, err := strconv.ParseUint(s, 0, result.Type().Bits())
 		if err == nil {
 			result.SetUint(i)
 		} else {
-			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
+			return parseErrorf(name, o, result.Type(), s, err)
 		}
 	default:
-		result.SetUint(o.ToUint())
+		i := o.ToInt()
+		if i < 0 || result.OverflowUint(uint64(i)) {
+			return fieldErrorf(name, o, result.Type(), "value %d overflows %s", i, result.Type())
+		}
+		result.SetUint(uint64(i))
 	}
 
 	return nil
 }
 
-func decodeIntoFloat64(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoFloat(name string, o *Object, result reflect.Value) error {
 	switch o.Type() {
-	case ObjectTypeFloat:
-		i, err := strconv.ParseFloat(o.ToString(), result.Type().Bits())
+	case ObjectTypeString:
+		s := d.stringValue(o)
+		f, err := strconv.ParseFloat(s, result.Type().Bits())
 		if err == nil {
-			result.SetFloat(i)
+			result.SetFloat(f)
 		} else {
-			return fmt.Errorf("cannot parse '%s' as int: %s", name, err)
+			return parseErrorf(name, o, result.Type(), s, err)
 		}
 	default:
-		result.SetFloat(o.ToFloat())
+		f := o.ToFloat()
+		if result.OverflowFloat(f) {
+			return fieldErrorf(name, o, result.Type(), "value %g overflows %s", f, result.Type())
+		}
+		result.SetFloat(f)
 	}
 
 	return nil
 }
 
-func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoDuration(name string, o *Object, result reflect.Value) error {
+	switch o.Type() {
+	case ObjectTypeInt:
+		// Plain integers are nanoseconds, as with any other int64
+		result.SetInt(o.ToInt())
+	case ObjectTypeTime, ObjectTypeFloat:
+		v, ok := secondsToDuration(o.ToFloat())
+		if !ok {
+			return fieldErrorf(name, o, result.Type(), "value %gs overflows %s", o.ToFloat(), result.Type())
+		}
+		result.SetInt(int64(v))
+	case ObjectTypeString:
+		s := d.stringValue(o)
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return parseErrorf(name, o, result.Type(), s, err)
+		}
+		result.SetInt(int64(v))
+	default:
+		return fieldErrorf(name, o, result.Type(), "unsupported type to duration: %v", o.Type())
+	}
+
+	return nil
+}
+
+func (d *Decoder) decodeIntoTime(name string, o *Object, result reflect.Value) error {
+	if o.Type() != ObjectTypeString {
+		return fieldErrorf(name, o, result.Type(), "unsupported type to time: %v", o.Type())
+	}
+
+	s := d.stringValue(o)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return parseErrorf(name, o, result.Type(), s, err)
+	}
+
+	result.Set(reflect.ValueOf(t))
+	return nil
+}
+
+func (d *Decoder) decodeIntoInterface(name string, o *Object, result reflect.Value) error {
+	// libucl keeps the values of a repeated key as an implicit array
+	if d.config.PlainMaps && o.object.next != nil {
+		values := make([]interface{}, 0, int(o.Len()))
+
+		i := 0
+		iter := o.Iterate(false)
+		defer iter.Close()
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			var raw interface{}
+			fieldName := fmt.Sprintf("%s[%d]", name, i)
+			err := d.decodeIntoInterfaceValue(fieldName, elem, reflect.ValueOf(&raw).Elem())
+			elem.Close()
+			if err != nil {
+				d.collect(err)
+			}
+
+			values = append(values, raw)
+			i++
+		}
+
+		result.Set(reflect.ValueOf(values))
+		return nil
+	}
+
+	return d.decodeIntoInterfaceValue(name, o, result)
+}
+
+// decodeIntoInterfaceValue decodes a single object into an interface,
+// ignoring any other values of a repeated key with PlainMaps.
+func (d *Decoder) decodeIntoInterfaceValue(name string, o *Object, result reflect.Value) error {
 	var set reflect.Value
 	redecode := true
 
@@ -121,18 +560,21 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 
 		result := make([]interface{}, 0, int(o.Len()))
 
+		i := 0
 		iter := o.Iterate(true)
 		defer iter.Close()
 		for o := iter.Next(); o != nil; o = iter.Next() {
 			raw := new(interface{})
-			err := decode(name, o, reflect.Indirect(reflect.ValueOf(raw)))
+			fieldName := fmt.Sprintf("%s[%d]", name, i)
+			err := d.decode(fieldName, o, reflect.Indirect(reflect.ValueOf(raw)))
 			o.Close()
 
 			if err != nil {
-				return err
+				d.collect(err)
 			}
 
 			result = append(result, *raw)
+			i++
 		}
 
 		set = reflect.ValueOf(result)
@@ -141,48 +583,82 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 	case ObjectTypeInt:
 		var result int
 		set = reflect.Indirect(reflect.New(reflect.TypeOf(result)))
+	case ObjectTypeFloat:
+		var result float64
+		set = reflect.Indirect(reflect.New(reflect.TypeOf(result)))
+	case ObjectTypeNull:
+		redecode = false
+		set = reflect.Zero(result.Type())
 	case ObjectTypeObject:
 		redecode = false
 
+		if d.config.PlainMaps {
+			m := make(map[string]interface{})
+
+			iter := o.Iterate(true)
+			defer iter.Close()
+			for elem := iter.Next(); elem != nil; elem = iter.Next() {
+				var raw interface{}
+				fieldName := elem.Key()
+				if name != "" {
+					fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+				}
+
+				err := d.decode(fieldName, elem, reflect.ValueOf(&raw).Elem())
+				key := elem.Key()
+				elem.Close()
+				if err != nil {
+					d.collect(err)
+				}
+
+				m[key] = raw
+			}
+
+			set = reflect.ValueOf(m)
+			break
+		}
+
 		result := make([]map[string]interface{}, 0, int(o.Len()))
 
-		var err error
 		outer := o.Iterate(false)
 		defer outer.Close()
 		for o := outer.Next(); o != nil; o = outer.Next() {
 			m := make(map[string]interface{})
 			inner := o.Iterate(true)
-		inner_loop:
 			for o2 := inner.Next(); o2 != nil; o2 = inner.Next() {
 				var raw interface{}
-				err = decode(name, o2, reflect.Indirect(reflect.ValueOf(&raw)))
+				fieldName := o2.Key()
+				if name != "" {
+					fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+				}
+
+				err := d.decode(fieldName, o2, reflect.Indirect(reflect.ValueOf(&raw)))
+				key := o2.Key()
 				o2.Close()
 				if err != nil {
-					break inner_loop
+					d.collect(err)
 				}
 
-				m[o2.Key()] = raw
+				m[key] = raw
 			}
 			inner.Close()
 			o.Close()
 
-			if err != nil {
-				return err
-			}
-
 			result = append(result, m)
 		}
 
 		set = reflect.ValueOf(result)
 	case ObjectTypeString:
 		set = reflect.Indirect(reflect.New(reflect.TypeOf("")))
+	case ObjectTypeTime:
+		set = reflect.Indirect(reflect.New(durationType))
 	default:
-		return fmt.Errorf(
-			"%s: unsupported type to interface: %v", name, o.Type())
+		return fieldErrorf(name, o, result.Type(),
+			"unsupported type to interface: %v", o.Type())
 	}
 
 	if redecode {
-		if err := decode(name, o, set); err != nil {
+		if err := d.decode(name, o, set); err != nil {
 			return err
 		}
 	}
@@ -191,16 +667,16 @@ func decodeIntoInterface(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoMap(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	if o.Type() != ObjectTypeObject {
-		return fmt.Errorf("%s: not an object type, can't decode to map", name)
+		return fieldErrorf(name, o, result.Type(), "not an object type, can't decode to map")
 	}
 
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
 	resultKeyType := resultType.Key()
 	if resultKeyType.Kind() != reflect.String {
-		return fmt.Errorf("%s: map must have string keys", name)
+		return fieldErrorf(name, o, result.Type(), "map must have string keys")
 	}
 
 	// Make a map to store our result
@@ -214,9 +690,11 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	defer outerIter.Close()
 	for outer := outerIter.Next(); outer != nil; outer = outerIter.Next() {
 		iter := outer.Iterate(true)
-		defer iter.Close()
 		for elem := iter.Next(); elem != nil; elem = iter.Next() {
-			fieldName := fmt.Sprintf("%s[%s]", name, elem.Key())
+			fieldName := elem.Key()
+			if name != "" {
+				fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+			}
 
 			key := reflect.ValueOf(elem.Key())
 
@@ -227,16 +705,21 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 			oldVal := resultMap.MapIndex(key)
 			if oldVal.IsValid() {
 				val.Set(oldVal)
+			} else {
+				d.decodeDefaults(fieldName, val)
 			}
 
-			err := decode(fieldName, elem, val)
+			err := d.decode(fieldName, elem, val)
 			elem.Close()
 			if err != nil {
-				return err
+				d.collect(err)
+				continue
 			}
 
 			resultMap.SetMapIndex(key, val)
 		}
+		iter.Close()
+		outer.Close()
 	}
 
 	// Set the final result
@@ -245,13 +728,14 @@ func decodeIntoMap(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoPtr(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoPtr(name string, o *Object, result reflect.Value) error {
 	// Create an element of the concrete (non pointer) type and decode
 	// into that. Then set the value of the pointer to this type.
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
 	val := reflect.New(resultElemType)
-	if err := decode(name, o, reflect.Indirect(val)); err != nil {
+	d.decodeDefaults(name, reflect.Indirect(val))
+	if err := d.decode(name, o, reflect.Indirect(val)); err != nil {
 		return err
 	}
 
@@ -259,7 +743,7 @@ func decodeIntoPtr(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoSlice(name string, o *Object, result reflect.Value) error {
 	// Create the slice
 	resultType := result.Type()
 	resultElemType := resultType.Elem()
@@ -282,10 +766,11 @@ func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
 	for elem := iter.Next(); elem != nil; elem = iter.Next() {
 		val := reflect.Indirect(reflect.New(resultElemType))
 		fieldName := fmt.Sprintf("%s[%d]", name, i)
-		err := decode(fieldName, elem, val)
+		d.decodeDefaults(fieldName, val)
+		err := d.decode(fieldName, elem, val)
 		elem.Close()
 		if err != nil {
-			return err
+			d.collect(err)
 		}
 
 		resultSlice = reflect.Append(resultSlice, val)
@@ -298,70 +783,80 @@ func decodeIntoSlice(name string, o *Object, result reflect.Value) error {
 	return nil
 }
 
-func decodeIntoString(name string, o *Object, result reflect.Value) error {
+func (d *Decoder) decodeIntoArray(name string, o *Object, result reflect.Value) error {
+	// Objects are decoded as a whole, as with slices
+	expand := o.Type() != ObjectTypeObject
+
+	// Start from zero values, so that short arrays don't keep old elements
+	resultArray := reflect.New(result.Type()).Elem()
+
+	i := 0
+	iter := o.Iterate(expand)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		if i >= resultArray.Len() {
+			elem.Close()
+			return fieldErrorf(name, o, result.Type(),
+				"too many elements for %s", result.Type())
+		}
+
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+		d.decodeDefaults(fieldName, resultArray.Index(i))
+		err := d.decode(fieldName, elem, resultArray.Index(i))
+		elem.Close()
+		if err != nil {
+			d.collect(err)
+		}
+
+		i++
+	}
+
+	result.Set(resultArray)
+
+	return nil
+}
+
+// decodeIntoBytes decodes a string into a byte slice, either as its raw
+// bytes or as base64.
+func (d *Decoder) decodeIntoBytes(name string, o *Object, result reflect.Value, b64 bool) error {
+	if o.Type() != ObjectTypeString {
+		return fieldErrorf(name, o, result.Type(), "unsupported type to bytes: %v", o.Type())
+	}
+
+	s := d.stringValue(o)
+	data := []byte(s)
+	if b64 {
+		var err error
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return fieldErrorf(name, o, result.Type(), "cannot parse %q as base64: %w", s, err)
+		}
+	}
+
+	result.SetBytes(data)
+	return nil
+}
+
+func (d *Decoder) decodeIntoString(name string, o *Object, result reflect.Value) error {
 	objType := o.Type()
 	switch objType {
 	case ObjectTypeBoolean:
 		result.SetString(strconv.FormatBool(o.ToBool()))
 	case ObjectTypeString:
-		result.SetString(o.ToString())
+		result.SetString(d.stringValue(o))
 	case ObjectTypeInt:
 		result.SetString(strconv.FormatInt(o.ToInt(), 10))
 	default:
-		return fmt.Errorf("%s: unsupported type to string: %v", name, objType)
+		return fieldErrorf(name, o, result.Type(), "unsupported type to string: %v", objType)
 	}
 
 	return nil
 }
 
-func decodeIntoStruct(name string, o *Object, result reflect.Value) error {
-	// This slice will keep track of all the structs we'll be decoding.
-	// There can be more than one struct if there are embedded structs
-	// that are squashed.
-	structs := make([]reflect.Value, 1, 5)
-	structs[0] = result
-
-	// Compile the list of all the fields that we're going to be decoding
-	// from all the structs.
-	fields := make(map[*reflect.StructField]reflect.Value)
-	for len(structs) > 0 {
-		structVal := structs[0]
-		structs = structs[1:]
-
-		structType := structVal.Type()
-	struct_loop:
-		for i := 0; i < structType.NumField(); i++ {
-			fieldType := structType.Field(i)
-
-			if fieldType.Anonymous {
-				fieldKind := fieldType.Type.Kind()
-				if fieldKind != reflect.Struct {
-					return fmt.Errorf(
-						"%s: unsupported type to struct: %s",
-						fieldType.Name, fieldKind)
-				}
-
-				// We have an embedded field. We "squash" the fields down
-				// if specified in the tag.
-				squash := false
-				tagParts := strings.Split(fieldType.Tag.Get(tagName), ",")
-			tag_loop:
-				for _, tag := range tagParts[1:] {
-					if tag == "squash" {
-						squash = true
-						break tag_loop
-					}
-				}
-
-				if squash {
-					structs = append(structs, result.FieldByName(fieldType.Name))
-					continue struct_loop
-				}
-			}
-
-			// Normal struct field, store it away
-			fields[&fieldType] = structVal.Field(i)
-		}
+func (d *Decoder) decodeIntoStruct(name string, o *Object, result reflect.Value) error {
+	fields, err := structFields(result)
+	if err != nil {
+		return fieldErrorf(name, o, result.Type(), "%w", err)
 	}
 
 	usedKeys := make(map[string]struct{})
@@ -369,7 +864,8 @@ func decodeIntoStruct(name string, o *Object, result reflect.Value) error {
 	var decodedFieldsVal []reflect.Value
 	var unusedKeysVal []reflect.Value
 field_loop:
-	for fieldType, field := range fields {
+	for _, f := range fields {
+		fieldType, field := &f.field, f.val
 		if !field.IsValid() {
 			// This should never happen
 			panic("field is not valid")
@@ -383,10 +879,23 @@ field_loop:
 
 		fieldName := fieldType.Name
 
+		b64 := false
+		required := false
+		envName := ""
 		tagValue := fieldType.Tag.Get(tagName)
-		tagParts := strings.SplitN(tagValue, ",", 2)
-		if len(tagParts) >= 2 {
-			switch tagParts[1] {
+		tagParts := strings.Split(tagValue, ",")
+		for _, option := range tagOptions(tagParts) {
+			if strings.HasPrefix(option, "env=") {
+				envName = strings.TrimPrefix(option, "env=")
+				continue
+			}
+
+			switch option {
+			case "base64":
+				b64 = field.Kind() == reflect.Slice &&
+					field.Type().Elem().Kind() == reflect.Uint8
+			case "required":
+				required = true
 			case "decodedFields":
 				decodedFieldsVal = append(decodedFieldsVal, field)
 				continue field_loop
@@ -397,8 +906,9 @@ field_loop:
 				// Increase the ref count
 				o.Ref()
 
-				// Sete the object
-				field.Set(reflect.ValueOf(o))
+				// Set the object, with its own wrapper so it can be
+				// closed independently of the one we're decoding
+				field.Set(reflect.ValueOf(newObject(o.object)))
 				continue field_loop
 			case "unusedKeys":
 				unusedKeysVal = append(unusedKeysVal, field)
@@ -411,7 +921,7 @@ field_loop:
 		}
 
 		elem := o.Get(fieldName)
-		if elem == nil {
+		if elem == nil && !d.config.CaseSensitive {
 			// Do a slower search by iterating over each key and
 			// doing case-insensitive search.
 			iter := o.Iterate(true)
@@ -424,25 +934,55 @@ field_loop:
 				elem.Close()
 			}
 			iter.Close()
+		}
+
+		// The path names the key as it is in the object, so that it
+		// can be found in the configuration. If the name is empty
+		// string, then we're at the root, and we don't dot-join the
+		// fields.
+		keyName := fieldName
+		if elem != nil {
+			keyName = elem.Key()
+		}
+		qualifiedName := keyName
+		if name != "" {
+			qualifiedName = fmt.Sprintf("%s.%s", name, keyName)
+		}
 
-			if elem == nil {
-				// No key matching this field.
+		if elem == nil {
+			// No key matching this field, which is fine if it has a
+			// default or is set by the environment.
+			_, hasDefault := tagDefault(tagParts)
+			if d.decodeEnv(qualifiedName, envName, field, b64) {
+				decodedFields = append(decodedFields, fieldType.Name)
 				continue field_loop
 			}
+			if required && !hasDefault {
+				d.collect(&FieldError{
+					Path:     qualifiedName,
+					Expected: field.Type(),
+					Actual:   ObjectTypeNull,
+					Err:      errors.New("missing required key"),
+				})
+			}
+
+			// The fields of a missing struct can still be set by the
+			// environment.
+			d.decodeEnvFields(qualifiedName, field)
+			continue field_loop
 		}
 
 		// Track the used key
 		usedKeys[elem.Key()] = struct{}{}
 
-		// If the name is empty string, then we're at the root, and we
-		// don't dot-join the fields.
-		if name != "" {
-			fieldName = fmt.Sprintf("%s.%s", name, fieldName)
-		}
-
 		var err error
-		if field.Kind() == reflect.Slice {
-			err = decode(fieldName, elem, field)
+		if b64 {
+			err = d.decodeIntoBytes(qualifiedName, elem, field, true)
+		} else if field.Kind() == reflect.Slice || field.Kind() == reflect.Array ||
+			(field.Kind() == reflect.Interface && d.config.PlainMaps) ||
+			field.Type() == objectPtrType {
+			// These take every value of a repeated key at once
+			err = d.decode(qualifiedName, elem, field)
 		} else {
 			iter := elem.Iterate(false)
 		iteration_loop:
@@ -452,7 +992,7 @@ field_loop:
 					break iteration_loop
 				}
 
-				err = decode(fieldName, obj, field)
+				err = d.decode(qualifiedName, obj, field)
 				obj.Close()
 				if err != nil {
 					break iteration_loop
@@ -463,9 +1003,13 @@ field_loop:
 		elem.Close()
 
 		if err != nil {
-			return err
+			d.collect(err)
+			continue field_loop
 		}
 
+		// The environment overrides the object
+		d.decodeEnv(qualifiedName, envName, field, b64)
+
 		decodedFields = append(decodedFields, fieldType.Name)
 	}
 
@@ -474,7 +1018,7 @@ field_loop:
 	}
 
 	// If we want to know what keys are unused, compile thta
-	if len(unusedKeysVal) > 0 {
+	if len(unusedKeysVal) > 0 || d.config.ErrorUnused {
 		unusedKeys := make([]string, 0, int(o.Len())-len(usedKeys))
 
 		iter := o.Iterate(true)
@@ -494,7 +1038,263 @@ field_loop:
 		for _, v := range unusedKeysVal {
 			v.Set(reflect.ValueOf(unusedKeys))
 		}
+
+		// A struct that collects its unused keys accepts any key
+		if len(unusedKeysVal) == 0 {
+			for _, k := range unusedKeys {
+				if name != "" {
+					k = fmt.Sprintf("%s.%s", name, k)
+				}
+				d.unused = append(d.unused, k)
+			}
+		}
 	}
 
 	return nil
 }
+
+type structField struct {
+	field reflect.StructField
+	val   reflect.Value
+}
+
+// structFields lists the fields of the struct result that are decoded, in
+// order so that errors are reported in the order of the fields. There can
+// be fields from more than one struct if there are embedded structs that
+// are squashed.
+func structFields(result reflect.Value) ([]structField, error) {
+	// This slice will keep track of all the structs we'll be decoding.
+	structs := make([]reflect.Value, 1, 5)
+	structs[0] = result
+
+	fields := make([]structField, 0, result.NumField())
+	for len(structs) > 0 {
+		structVal := structs[0]
+		structs = structs[1:]
+
+		structType := structVal.Type()
+	struct_loop:
+		for i := 0; i < structType.NumField(); i++ {
+			fieldType := structType.Field(i)
+
+			if fieldType.Anonymous {
+				fieldKind := fieldType.Type.Kind()
+				if fieldKind != reflect.Struct {
+					return nil, fmt.Errorf(
+						"embedded field %s: unsupported type to struct: %s",
+						fieldType.Name, fieldKind)
+				}
+
+				// We have an embedded field. We "squash" the fields down
+				// if specified in the tag.
+				squash := false
+				tagParts := strings.Split(fieldType.Tag.Get(tagName), ",")
+			tag_loop:
+				for _, tag := range tagOptions(tagParts) {
+					if tag == "squash" {
+						squash = true
+						break tag_loop
+					}
+				}
+
+				if squash {
+					structs = append(structs, result.FieldByName(fieldType.Name))
+					continue struct_loop
+				}
+			}
+
+			// Normal struct field, store it away
+			fields = append(fields, structField{fieldType, structVal.Field(i)})
+		}
+	}
+
+	return fields, nil
+}
+
+// decodeDefaults sets the default values of a value that is about to be
+// decoded: SetDefaults is called if the type implements Defaulter, then
+// for structs the `default` options of the fields are decoded, before
+// recursing into the fields that are structs themselves.
+func (d *Decoder) decodeDefaults(name string, result reflect.Value) {
+	if result.CanAddr() {
+		if v, ok := result.Addr().Interface().(Defaulter); ok {
+			v.SetDefaults()
+		}
+
+		// Types that decode themselves don't have fields of ours
+		ptr := result.Addr().Type()
+		if ptr.Implements(unmarshalerType) || ptr.Implements(textUnmarshalerType) {
+			return
+		}
+	}
+
+	if result.Kind() != reflect.Struct || result.Type() == timeType {
+		return
+	}
+
+	fields, err := structFields(result)
+	if err != nil {
+		d.collect(&FieldError{
+			Path:     name,
+			Expected: result.Type(),
+			Actual:   ObjectTypeNull,
+			Err:      err,
+		})
+		return
+	}
+
+	for _, f := range fields {
+		if !f.val.CanSet() {
+			continue
+		}
+
+		tagParts := strings.Split(f.field.Tag.Get(tagName), ",")
+		fieldName := f.field.Name
+		if tagParts[0] != "" {
+			fieldName = tagParts[0]
+		}
+		if name != "" {
+			fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+		}
+
+		_, b64 := tagEnv(tagParts, f.val)
+		if value, ok := tagDefault(tagParts); ok {
+			// Keep values that are already set, by the caller or by
+			// SetDefaults
+			if f.val.IsZero() {
+				d.decodeTagValue(fieldName, value, f.val, b64)
+			}
+		} else {
+			d.decodeDefaults(fieldName, f.val)
+		}
+	}
+}
+
+// decodeEnvFields decodes the variables named by the `env` options of the
+// fields of a struct whose key is missing, and those of its own structs.
+// The fields of a struct whose key is present are left to decodeIntoStruct.
+func (d *Decoder) decodeEnvFields(name string, result reflect.Value) {
+	if result.Kind() != reflect.Struct {
+		return
+	}
+
+	// decodeDefaults has already reported the error
+	fields, err := structFields(result)
+	if err != nil {
+		return
+	}
+
+	for _, f := range fields {
+		if !f.val.CanSet() {
+			continue
+		}
+
+		tagParts := strings.Split(f.field.Tag.Get(tagName), ",")
+		fieldName := f.field.Name
+		if tagParts[0] != "" {
+			fieldName = tagParts[0]
+		}
+		if name != "" {
+			fieldName = fmt.Sprintf("%s.%s", name, fieldName)
+		}
+
+		envName, b64 := tagEnv(tagParts, f.val)
+		if !d.decodeEnv(fieldName, envName, f.val, b64) {
+			d.decodeEnvFields(fieldName, f.val)
+		}
+	}
+}
+
+// tagEnv returns the variable named by the `env` option of a field, and
+// whether its `base64` option applies to the field.
+func tagEnv(tagParts []string, field reflect.Value) (string, bool) {
+	envName := ""
+	b64 := false
+	for _, option := range tagOptions(tagParts) {
+		switch {
+		case option == "base64":
+			b64 = field.Kind() == reflect.Slice &&
+				field.Type().Elem().Kind() == reflect.Uint8
+		case strings.HasPrefix(option, "env="):
+			envName = strings.TrimPrefix(option, "env=")
+		}
+	}
+	return envName, b64
+}
+
+// decodeTagValue decodes the value of a field's `default` option, or of the
+// variable named by its `env` option.
+func (d *Decoder) decodeTagValue(name, value string, result reflect.Value, b64 bool) {
+	var o *Object
+	switch {
+	case result.Kind() == reflect.String,
+		result.Kind() == reflect.Slice && result.Type().Elem().Kind() == reflect.Uint8:
+		o = NewObject(value)
+	default:
+		o = NewFormattedObject(value, StringParse)
+	}
+	defer o.Close()
+
+	var err error
+	if b64 {
+		err = d.decodeIntoBytes(name, o, result, true)
+	} else {
+		err = d.decode(name, o, result)
+	}
+	if err != nil {
+		d.collect(err)
+	}
+}
+
+// decodeEnv decodes the variable named by a field's `env` option into the
+// field, and reports whether the variable was set.
+func (d *Decoder) decodeEnv(name, envName string, result reflect.Value, b64 bool) bool {
+	if envName == "" {
+		return false
+	}
+
+	value, ok := d.lookupEnv(envName)
+	if !ok {
+		return false
+	}
+
+	d.decodeTagValue(name, value, result, b64)
+	return true
+}
+
+func (d *Decoder) lookupEnv(name string) (string, bool) {
+	if d.config.LookupEnv != nil {
+		return d.config.LookupEnv(name)
+	}
+	return os.LookupEnv(name)
+}
+
+// stringValue returns the value of a string object, with any variables
+// expanded if ExpandEnv is set.
+func (d *Decoder) stringValue(o *Object) string {
+	s := o.ToString()
+	if !d.config.ExpandEnv {
+		return s
+	}
+
+	var buf strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+
+		value, _ := d.lookupEnv(s[start+2 : end])
+		buf.WriteString(s[:start])
+		buf.WriteString(value)
+		s = s[end+1:]
+	}
+	buf.WriteString(s)
+
+	return buf.String()
+}
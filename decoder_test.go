@@ -1,9 +1,14 @@
 package libucl
 
 import (
+	"errors"
+	"fmt"
+	"net"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 type foobarSorter []string
@@ -226,6 +231,83 @@ func TestObjectDecode_mapObjectMultiple(t *testing.T) {
 	}
 }
 
+func TestObjectDecode_generic(t *testing.T) {
+	obj := testParseString(t, `
+	foo = bar
+	bar { baz = "what"; inner { ratio = 0.5; none = null; } }
+	multi { port = 80 }
+	multi { port = 3000 }
+	list = [1, { a = b }]
+	timeout = 10s
+	`)
+	defer obj.Close()
+
+	result, err := obj.DecodeGeneric()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"foo": "bar",
+		"bar": map[string]interface{}{
+			"baz": "what",
+			"inner": map[string]interface{}{
+				"ratio": 0.5,
+				"none":  nil,
+			},
+		},
+		"multi": []interface{}{
+			map[string]interface{}{"port": 80},
+			map[string]interface{}{"port": 3000},
+		},
+		"list": []interface{}{
+			1,
+			map[string]interface{}{"a": "b"},
+		},
+		"timeout": 10 * time.Second,
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecoder_plainMapsRepeatedField(t *testing.T) {
+	type Config struct {
+		Any    interface{} `libucl:"any"`
+		Object *Object     `libucl:"object"`
+	}
+
+	obj := testParseString(t, `any = 1; any = 2; object = "a"; object = "b";`)
+	defer obj.Close()
+
+	var result Config
+	d, err := NewDecoder(&DecoderConfig{Result: &result, PlainMaps: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.Decode(obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer result.Object.Close()
+
+	// Repeated keys are kept whole, as they are for a top-level interface
+	if !reflect.DeepEqual(result.Any, []interface{}{1, 2}) {
+		t.Fatalf("bad: %#v", result.Any)
+	}
+
+	var values []string
+	iter := result.Object.Iterate(false)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		values = append(values, elem.ToString())
+		elem.Close()
+	}
+	if !reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Fatalf("bad: %#v", values)
+	}
+}
+
 func TestObjectDecode_mapReuseVal(t *testing.T) {
 	type Struct struct {
 		Foo string
@@ -550,3 +632,838 @@ func TestObjectDecode_structUnusedKeys(t *testing.T) {
 		t.Fatalf("bad: %#v", result)
 	}
 }
+
+func TestObjectDecode_time(t *testing.T) {
+	type Time struct {
+		Timeout  time.Duration
+		Interval time.Duration
+		Delay    time.Duration
+		Nanos    time.Duration
+		Fraction time.Duration
+		Seconds  float64
+		Created  time.Time
+		Any      interface{}
+	}
+
+	obj := testParseString(t, `
+	timeout = 10s; interval = 5min; delay = "1h30m";
+	nanos = 5; fraction = 0.25;
+	seconds = 1.5s;
+	created = "2020-01-02T03:04:05Z";
+	any = 2min;
+	`)
+	defer obj.Close()
+
+	var result Time
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Time{
+		Timeout:  10 * time.Second,
+		Interval: 5 * time.Minute,
+		Delay:    90 * time.Minute,
+		Nanos:    5,
+		Fraction: 250 * time.Millisecond,
+		Seconds:  1.5,
+		Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Any:      2 * time.Minute,
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_timeInvalid(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Result interface{}
+	}{
+		{`value = "soon"`, new(struct{ Value time.Duration })},
+		{`value = true`, new(struct{ Value time.Duration })},
+		{`value = 1e10`, new(struct{ Value time.Duration })},
+		{`value = 1000000000d`, new(struct{ Value time.Duration })},
+		{`value = "yesterday"`, new(struct{ Value time.Time })},
+		{`value = 10`, new(struct{ Value time.Time })},
+	}
+
+	for _, tc := range cases {
+		obj := testParseString(t, tc.Input)
+		err := obj.Decode(tc.Result)
+		obj.Close()
+		if err == nil {
+			t.Fatalf("should error: %s", tc.Input)
+		}
+	}
+}
+
+type testUnmarshaler struct {
+	Type  ObjectType
+	Value string
+}
+
+func (u *testUnmarshaler) UnmarshalUCL(o *Object) error {
+	if o.Type() == ObjectTypeBoolean {
+		return errors.New("no booleans")
+	}
+
+	u.Type = o.Type()
+	u.Value = o.Key()
+	return nil
+}
+
+type testLevel int
+
+func (l *testLevel) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "low":
+		*l = 1
+	case "high":
+		*l = 2
+	default:
+		return fmt.Errorf("unknown level: %s", text)
+	}
+	return nil
+}
+
+type testHooked struct {
+	Parts []string
+}
+
+func testHookedHook(from ObjectType, to reflect.Type, o *Object) (interface{}, error) {
+	if to != reflect.TypeOf(testHooked{}) {
+		return nil, nil
+	}
+	if from != ObjectTypeString {
+		return nil, errors.New("expected a string")
+	}
+
+	return testHooked{Parts: strings.Split(o.ToString(), ",")}, nil
+}
+
+func TestObjectDecode_unmarshaler(t *testing.T) {
+	type Struct struct {
+		Custom testUnmarshaler
+		Ptr    *testUnmarshaler
+		IP     net.IP
+		Level  testLevel
+		Levels []testLevel
+		Number testLevel
+	}
+
+	obj := testParseString(t, `
+	custom = 12; ptr { a = b; }
+	ip = "192.0.2.1";
+	level = "high"; levels = ["low", "high"]; number = 3;
+	`)
+	defer obj.Close()
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{
+		Custom: testUnmarshaler{Type: ObjectTypeInt, Value: "custom"},
+		Ptr:    &testUnmarshaler{Type: ObjectTypeObject, Value: "ptr"},
+		IP:     net.ParseIP("192.0.2.1"),
+		Level:  2,
+		Levels: []testLevel{1, 2},
+		Number: 3,
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_unmarshalerError(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Result interface{}
+	}{
+		{`value = true`, new(struct{ Value testUnmarshaler })},
+		{`value = "medium"`, new(struct{ Value testLevel })},
+	}
+
+	for _, tc := range cases {
+		obj := testParseString(t, tc.Input)
+		err := obj.Decode(tc.Result)
+		obj.Close()
+		if err == nil {
+			t.Fatalf("should error: %s", tc.Input)
+		}
+		if !strings.HasPrefix(err.Error(), "value:") {
+			t.Fatalf("error should name the field: %s", err)
+		}
+	}
+}
+
+func TestDecoder_decodeHooks(t *testing.T) {
+	type Struct struct {
+		Hooked testHooked
+		List   []testHooked
+	}
+
+	obj := testParseString(t, `hooked = "a,b,c"; list = ["d", "e,f"];`)
+	defer obj.Close()
+
+	var result Struct
+	d, err := NewDecoder(&DecoderConfig{
+		Result:      &result,
+		DecodeHooks: []DecodeHook{testHookedHook},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.Decode(obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Struct{
+		Hooked: testHooked{Parts: []string{"a", "b", "c"}},
+		List: []testHooked{
+			{Parts: []string{"d"}},
+			{Parts: []string{"e", "f"}},
+		},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The hooks only apply to the decoder they were given to
+	var other Struct
+	obj.Decode(&other)
+	if other.Hooked.Parts != nil {
+		t.Fatalf("bad: %#v", other)
+	}
+
+	bad := testParseString(t, `hooked = 12;`)
+	defer bad.Close()
+	d, err = NewDecoder(&DecoderConfig{
+		Result:      &result,
+		DecodeHooks: []DecodeHook{testHookedHook},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	err = d.Decode(bad)
+	if err == nil || !strings.HasPrefix(err.Error(), "hooked:") {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestDecoder_decodeHooksType(t *testing.T) {
+	var result struct {
+		Name string `libucl:"name"`
+	}
+
+	obj := testParseString(t, `name = 65;`)
+	defer obj.Close()
+
+	// Values are never converted, as an int would become a one-rune string
+	hook := func(from ObjectType, to reflect.Type, o *Object) (interface{}, error) {
+		return int(o.ToInt()), nil
+	}
+	d, err := NewDecoder(&DecoderConfig{
+		Result:      &result,
+		DecodeHooks: []DecodeHook{hook},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = d.Decode(obj)
+	if err == nil || err.Error() != "name: decode hook returned int, expected string" {
+		t.Fatalf("bad: %v", err)
+	}
+	if result.Name != "" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_numericKinds(t *testing.T) {
+	type Numbers struct {
+		I8   int8
+		I16  int16
+		I32  int32
+		U8   uint8
+		U16  uint16
+		U32  uint32
+		Ptr  uintptr
+		F32  float32
+		F64  float64
+		FStr float64
+	}
+
+	obj := testParseString(t, `
+	i8 = -128; i16 = 32767; i32 = -2147483648;
+	u8 = 255; u16 = 65535; u32 = 4294967295; ptr = 16;
+	f32 = 1.5; f64 = 0.25; fstr = "2.5";
+	`)
+	defer obj.Close()
+
+	var result Numbers
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Numbers{
+		I8: -128, I16: 32767, I32: -2147483648,
+		U8: 255, U16: 65535, U32: 4294967295, Ptr: 16,
+		F32: 1.5, F64: 0.25, FStr: 2.5,
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_overflow(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Result interface{}
+	}{
+		{`value = 128`, new(struct{ Value int8 })},
+		{`value = "40000"`, new(struct{ Value int16 })},
+		{`value = 256`, new(struct{ Value uint8 })},
+		{`value = -1`, new(struct{ Value uint32 })},
+		{`value = 1e300`, new(struct{ Value float32 })},
+		{`inner { value = 300 }`, new(struct{ Inner struct{ Value uint8 } })},
+		{`list = [1, 1000]`, new(struct{ List []int8 })},
+	}
+
+	for _, tc := range cases {
+		obj := testParseString(t, tc.Input)
+		err := obj.Decode(tc.Result)
+		obj.Close()
+		if err == nil {
+			t.Fatalf("should error: %s", tc.Input)
+		}
+	}
+
+	obj := testParseString(t, `inner { list = [1, 1000] }`)
+	defer obj.Close()
+
+	var result struct {
+		Inner struct {
+			List []int8 `libucl:"list"`
+		} `libucl:"inner"`
+	}
+	err := obj.Decode(&result)
+	if err == nil || !strings.HasPrefix(err.Error(), "inner.list[1]:") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestObjectDecode_array(t *testing.T) {
+	type Array struct {
+		RGB   [3]uint8
+		Short [4]string
+		One   [1]int
+	}
+
+	obj := testParseString(t, `rgb = [255, 128, 0]; short = ["a", "b"]; one = 7;`)
+	defer obj.Close()
+
+	result := Array{Short: [4]string{"x", "x", "x", "x"}}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Array{
+		RGB:   [3]uint8{255, 128, 0},
+		Short: [4]string{"a", "b"},
+		One:   [1]int{7},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	obj2 := testParseString(t, `rgb = [1, 2, 3, 4];`)
+	defer obj2.Close()
+	if err := obj2.Decode(&result); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestObjectDecode_arrayRepeatedKey(t *testing.T) {
+	type Array struct {
+		Ports [2]int
+	}
+
+	obj := testParseString(t, `ports = 1; ports = 2;`)
+	defer obj.Close()
+
+	var result Array
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Array{Ports: [2]int{1, 2}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_bytes(t *testing.T) {
+	type Bytes struct {
+		Raw     []byte
+		Encoded []byte `libucl:"encoded,base64"`
+		Padless []byte `libucl:"padless,base64"`
+		Array   []byte
+	}
+
+	obj := testParseString(t, `
+	raw = "hello"; encoded = "aGVsbG8="; padless = "aGVsbG8";
+	array = [104, 105];
+	`)
+	defer obj.Close()
+
+	var result Bytes
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Bytes{
+		Raw:     []byte("hello"),
+		Encoded: []byte("hello"),
+		Padless: []byte("hello"),
+		Array:   []byte("hi"),
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	obj2 := testParseString(t, `encoded = "not base64!";`)
+	defer obj2.Close()
+	if err := obj2.Decode(&result); err == nil {
+		t.Fatal("should error")
+	}
+}
+
+func TestDecoder_errorUnused(t *testing.T) {
+	type TLS struct {
+		Cert string `libucl:"cert"`
+	}
+	type Server struct {
+		Port int `libucl:"port"`
+		TLS  TLS `libucl:"tls"`
+	}
+	type Config struct {
+		Server  Server            `libucl:"server"`
+		Labels  map[string]string `libucl:"labels"`
+		Plugins []struct {
+			Name  string   `libucl:"name"`
+			Extra []string `libucl:",unusedKeys"`
+		} `libucl:"plugins"`
+	}
+
+	obj := testParseString(t, `
+	server { port = 80; tls { cert = "a"; crt = "b"; } }
+	server { porrt = 81; }
+	labels { anything = "goes"; }
+	plugins = [{ name = "foo"; other = true; }]
+	typo = 1;
+	`)
+	defer obj.Close()
+
+	var result Config
+	d, err := NewDecoder(&DecoderConfig{Result: &result, ErrorUnused: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = d.Decode(obj)
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	expected := "unknown keys: server.porrt, server.tls.crt, typo"
+	if err.Error() != expected {
+		t.Fatalf("bad: %s", err)
+	}
+
+	// Without the option, unknown keys are ignored
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestDecoder_required(t *testing.T) {
+	type Config struct {
+		Name   string `libucl:"name,required"`
+		Server struct {
+			Port int `libucl:"port,required"`
+		} `libucl:"server"`
+	}
+
+	obj := testParseString(t, `name = "foo"; server { host = "example"; }`)
+	defer obj.Close()
+
+	var result Config
+	err := obj.Decode(&result)
+	if err == nil || err.Error() != "server.port: missing required key" {
+		t.Fatalf("bad: %v", err)
+	}
+
+	obj2 := testParseString(t, `name = "foo"; server { port = 80; }`)
+	defer obj2.Close()
+	if err := obj2.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestDecoder_caseSensitive(t *testing.T) {
+	type Config struct {
+		Name string `libucl:"name"`
+	}
+
+	obj := testParseString(t, `NAME = "foo";`)
+	defer obj.Close()
+
+	var result Config
+	if err := obj.Decode(&result); err != nil || result.Name != "foo" {
+		t.Fatalf("bad: %#v %v", result, err)
+	}
+
+	result = Config{}
+	d, err := NewDecoder(&DecoderConfig{Result: &result, CaseSensitive: true})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.Decode(obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Name != "" {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecoder_errors(t *testing.T) {
+	type Listen struct {
+		Port uint16 `libucl:"port"`
+	}
+	type Server struct {
+		Name   string `libucl:"name,required"`
+		Listen Listen `libucl:"listen"`
+	}
+	type Config struct {
+		Servers []Server `libucl:"servers"`
+		Ratio   float64  `libucl:"ratio"`
+	}
+
+	obj := testParseString(t, `
+	servers = [
+		{ name = "a"; listen { port = 80 } },
+		{ name = "b"; listen { port = "http" } },
+		{ name = "c"; listen { port = 70000 } },
+		{ listen { port = 443 } },
+	]
+	ratio = "half"
+	`)
+	defer obj.Close()
+
+	var result Config
+	err := obj.Decode(&result)
+
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	expected := []string{
+		`servers[1].listen.port: cannot parse "http" as uint16: invalid syntax`,
+		`servers[2].listen.port: value 70000 overflows uint16`,
+		`servers[3].name: missing required key`,
+		`ratio: cannot parse "half" as float64: invalid syntax`,
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Fatalf("bad: %s", err)
+	}
+
+	ferr := derr.Errors[1]
+	if ferr.Path != "servers[2].listen.port" ||
+		ferr.Expected != reflect.TypeOf(uint16(0)) ||
+		ferr.Actual != ObjectTypeInt {
+		t.Fatalf("bad: %#v", ferr)
+	}
+
+	// The values that could be decoded still are
+	if result.Servers[0].Listen.Port != 80 || result.Servers[3].Listen.Port != 443 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectDecode_errorPaths(t *testing.T) {
+	type Group struct {
+		Port uint16
+	}
+	type Config struct {
+		Ports  map[string]uint16
+		Groups map[string]Group
+	}
+
+	obj := testParseString(t, `
+	ports { http = 80; https = "secure"; }
+	groups { web { port = -1; } }
+	`)
+	defer obj.Close()
+
+	var result Config
+	err := obj.Decode(&result)
+
+	var derr *DecodeError
+	if !errors.As(err, &derr) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// Map keys are joined like struct fields
+	var paths []string
+	for _, ferr := range derr.Errors {
+		paths = append(paths, ferr.Path)
+	}
+	expected := []string{"ports.https", "groups.web.port"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("bad: %#v", paths)
+	}
+}
+
+type testDefaults struct {
+	Host    string        `libucl:"host,default=localhost"`
+	Port    int           `libucl:"port,required,default=8080"`
+	Timeout time.Duration `libucl:"timeout,default=30s"`
+	Tags    []string      `libucl:"tags,default=a,b"`
+	Debug   bool          `libucl:"debug"`
+	Retries int           `libucl:"retries"`
+}
+
+func (d *testDefaults) SetDefaults() {
+	d.Debug = true
+	d.Retries = 3
+}
+
+func TestDecoder_defaults(t *testing.T) {
+	type Config struct {
+		Server  testDefaults            `libucl:"server"`
+		Backups []testDefaults          `libucl:"backups"`
+		Named   map[string]testDefaults `libucl:"named"`
+		Ratio   float64                 `libucl:"ratio,default=0.5"`
+	}
+
+	obj := testParseString(t, `
+	server { port = 80; }
+	server { debug = false; }
+	backups = [{ host = "backup"; }]
+	`)
+	defer obj.Close()
+
+	var result Config
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	defaults := testDefaults{
+		Host:    "localhost",
+		Port:    8080,
+		Timeout: 30 * time.Second,
+		Tags:    []string{"a,b"},
+		Debug:   true,
+		Retries: 3,
+	}
+
+	expected := Config{
+		Server:  defaults,
+		Backups: []testDefaults{defaults},
+		Ratio:   0.5,
+	}
+	expected.Server.Port = 80
+	expected.Server.Debug = false
+	expected.Backups[0].Host = "backup"
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+type testDefaultsInner struct {
+	Port int    `libucl:"port,default=80"`
+	Host string `libucl:"host,default=localhost"`
+}
+
+// testDefaultsText decodes itself, and has fields that Decode can't handle.
+type testDefaultsText struct {
+	error
+	text string
+}
+
+func (t *testDefaultsText) UnmarshalText(text []byte) error {
+	t.text = string(text)
+	return nil
+}
+
+type testDefaultsOuter struct {
+	Inner   testDefaultsInner `libucl:"inner"`
+	Name    string            `libucl:"name,default=foo"`
+	Label   testDefaultsText  `libucl:"label"`
+	Created time.Time         `libucl:"created"`
+}
+
+func (o *testDefaultsOuter) SetDefaults() {
+	o.Inner.Port = 8080
+}
+
+func TestDecoder_defaultsKept(t *testing.T) {
+	obj := testParseString(t, `label = "x";`)
+	defer obj.Close()
+
+	result := testDefaultsOuter{Name: "preset"}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The outer SetDefaults beats the tag of the nested struct, values
+	// already in the result are kept, and types that decode themselves
+	// aren't looked into.
+	expected := testDefaultsOuter{
+		Inner: testDefaultsInner{Port: 8080, Host: "localhost"},
+		Name:  "preset",
+		Label: testDefaultsText{text: "x"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecoder_defaultsInvalid(t *testing.T) {
+	var result struct {
+		Port int `libucl:"port,default=http"`
+	}
+
+	obj := testParseString(t, `name = "foo";`)
+	defer obj.Close()
+
+	err := obj.Decode(&result)
+	if err == nil || !strings.HasPrefix(err.Error(), "port:") {
+		t.Fatalf("bad: %v", err)
+	}
+}
+
+func TestDecoder_env(t *testing.T) {
+	type Server struct {
+		Host string `libucl:"host,env=TEST_HOST"`
+		Port int    `libucl:"port,env=TEST_PORT,default=80"`
+	}
+	type Config struct {
+		Name    string `libucl:"name"`
+		Path    string `libucl:"path"`
+		Server  Server `libucl:"server"`
+		Missing Server `libucl:"missing"`
+	}
+
+	env := map[string]string{
+		"TEST_HOST": "example.com",
+		"TEST_PORT": "8080",
+		"USER":      "alice",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	obj := testParseString(t, `
+	name = "${USER}-${UNSET}";
+	path = "/home/${USER}/${";
+	server { host = "localhost"; port = 9000; }
+	`)
+	defer obj.Close()
+
+	var result Config
+	d, err := NewDecoder(&DecoderConfig{
+		Result:    &result,
+		ExpandEnv: true,
+		LookupEnv: lookup,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.Decode(obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := Config{
+		Name:    "alice-",
+		Path:    "/home/alice/${",
+		Server:  Server{Host: "example.com", Port: 8080},
+		Missing: Server{Host: "example.com", Port: 8080},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Without ExpandEnv, strings are left alone
+	result = Config{}
+	d, err = NewDecoder(&DecoderConfig{Result: &result, LookupEnv: lookup})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := d.Decode(obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result.Name != "${USER}-${UNSET}" || result.Server.Port != 8080 {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestDecoder_envInvalid(t *testing.T) {
+	type Server struct {
+		Port int `libucl:"port,env=TEST_PORT,default=80"`
+	}
+	type Config struct {
+		Server  Server            `libucl:"server"`
+		Missing Server            `libucl:"missing"`
+		Servers map[string]Server `libucl:"servers"`
+	}
+
+	lookup := func(name string) (string, bool) {
+		if name == "TEST_PORT" {
+			return "http", true
+		}
+		return "", false
+	}
+
+	obj := testParseString(t, `
+	server { port = 9000; }
+	servers { a { port = 9001; } }
+	`)
+	defer obj.Close()
+
+	var result Config
+	d, err := NewDecoder(&DecoderConfig{Result: &result, LookupEnv: lookup})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var derr *DecodeError
+	if err := d.Decode(obj); !errors.As(err, &derr) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// Each bad value is reported once, with its full path
+	var paths []string
+	for _, ferr := range derr.Errors {
+		paths = append(paths, ferr.Path)
+	}
+	expected := []string{"server.port", "missing.port", "servers.a.port"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("bad: %#v", paths)
+	}
+}
+
+func TestNewDecoder_invalid(t *testing.T) {
+	var result struct{}
+	if _, err := NewDecoder(&DecoderConfig{Result: result}); err == nil {
+		t.Fatal("should error")
+	}
+}
@@ -0,0 +1,103 @@
+package libucl
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// emitState is the destination of a single EmitTo call.
+type emitState struct {
+	w   *bufio.Writer
+	err error
+}
+
+// Keeps track of all the running emitters internally
+var emitters map[int]*emitState
+var emittersIdx int
+var emittersLock sync.Mutex
+
+// EmitTo converts this object to another format and writes it to w as it is
+// generated, rather than building the whole output in memory first.
+//
+// The first error returned by w stops any further writes and is returned.
+func (o *Object) EmitTo(w io.Writer, t Emitter) error {
+	defer runtime.KeepAlive(o)
+	state := &emitState{w: bufio.NewWriter(w)}
+
+	emittersLock.Lock()
+	if emitters == nil {
+		emitters = make(map[int]*emitState)
+	}
+	for emitters[emittersIdx] != nil {
+		emittersIdx++
+	}
+	idx := emittersIdx
+	emitters[idx] = state
+	emittersIdx++
+	emittersLock.Unlock()
+
+	defer func() {
+		emittersLock.Lock()
+		defer emittersLock.Unlock()
+		delete(emitters, idx)
+	}()
+
+	ok := C._go_emit_full(o.object, uint32(t), C.int(idx))
+	if state.err != nil {
+		return state.err
+	}
+	if !ok {
+		return errors.New("failed to emit object")
+	}
+
+	return state.w.Flush()
+}
+
+func lookupEmitter(idx C.int) *emitState {
+	emittersLock.Lock()
+	defer emittersLock.Unlock()
+	return emitters[int(idx)]
+}
+
+func (s *emitState) write(data []byte) C.int {
+	if s.err != nil {
+		return -1
+	}
+
+	if _, err := s.w.Write(data); err != nil {
+		s.err = err
+		return -1
+	}
+	return 0
+}
+
+//export go_emit_append_character
+func go_emit_append_character(idx C.int, c C.uchar, n C.size_t) C.int {
+	s := lookupEmitter(idx)
+	if s == nil {
+		return -1
+	}
+
+	data := make([]byte, int(n))
+	for i := range data {
+		data[i] = byte(c)
+	}
+	return s.write(data)
+}
+
+//export go_emit_append_len
+func go_emit_append_len(idx C.int, str *C.uchar, n C.size_t) C.int {
+	s := lookupEmitter(idx)
+	if s == nil {
+		return -1
+	}
+
+	return s.write(C.GoBytes(unsafe.Pointer(str), C.int(n)))
+}
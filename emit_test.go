@@ -0,0 +1,50 @@
+package libucl
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type errWriter struct {
+	n int
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("write failed")
+}
+
+func TestObjectEmitTo(t *testing.T) {
+	obj := testParseString(t, "foo = bar; bar = [1, 2.5, true];")
+	defer obj.Close()
+
+	for _, emitter := range []Emitter{EmitJSON, EmitJSONCompact, EmitConfig, EmitYAML} {
+		expected, err := obj.Emit(emitter)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if err := obj.EmitTo(&buf, emitter); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if buf.String() != expected {
+			t.Fatalf("bad: %#v, expected: %#v", buf.String(), expected)
+		}
+	}
+}
+
+func TestObjectEmitTo_writeError(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	w := new(errWriter)
+	if err := obj.EmitTo(w, EmitJSON); err == nil {
+		t.Fatal("should fail")
+	}
+	if w.n != 1 {
+		t.Fatalf("bad: %d", w.n)
+	}
+}
@@ -0,0 +1,288 @@
+package libucl
+
+import (
+	"encoding"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+var (
+	objectPtrType     = reflect.TypeOf((*Object)(nil))
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Encode encodes a native Go structure into a libucl object. It understands
+// the same struct tags as Decode, so anything produced by Encode can be
+// decoded back into the same type. Types that implement
+// encoding.TextMarshaler are encoded as strings.
+//
+// The returned object must be closed when you're done using it.
+func Encode(v interface{}) (*Object, error) {
+	obj, err := encode("", reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+
+	return newObject(obj), nil
+}
+
+// Marshal encodes a native Go structure and emits it in the given format.
+func Marshal(v interface{}, t Emitter) ([]byte, error) {
+	obj, err := Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	result, err := obj.Emit(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(result), nil
+}
+
+func encode(name string, v reflect.Value) (*C.ucl_object_t, error) {
+	if !v.IsValid() {
+		return C.ucl_object_typed_new(C.UCL_NULL), nil
+	}
+
+	// Objects are copied as-is, since we can't share them between trees.
+	if v.Type() == objectPtrType {
+		if v.IsNil() {
+			return C.ucl_object_typed_new(C.UCL_NULL), nil
+		}
+
+		return C.ucl_object_copy(v.Interface().(*Object).object), nil
+	}
+
+	switch v.Type() {
+	case durationType:
+		return encodeDuration(time.Duration(v.Int())), nil
+	case timeType:
+		return encodeString(v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	}
+
+	// Types that can be decoded from text are encoded as text
+	if m := textMarshaler(v); m != nil {
+		text, err := m.MarshalText()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return encodeString(string(text)), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return C.ucl_object_frombool(C.bool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return C.ucl_object_fromint(C.int64_t(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("%s: value %d overflows int64", name, u)
+		}
+		return C.ucl_object_fromint(C.int64_t(u)), nil
+	case reflect.Float32, reflect.Float64:
+		return C.ucl_object_fromdouble(C.double(v.Float())), nil
+	case reflect.String:
+		return encodeString(v.String()), nil
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return C.ucl_object_typed_new(C.UCL_NULL), nil
+		}
+		return encode(name, v.Elem())
+	case reflect.Map:
+		return encodeMap(name, v)
+	case reflect.Slice, reflect.Array:
+		// Byte slices are decoded from strings
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return encodeString(string(v.Bytes())), nil
+		}
+		return encodeSlice(name, v)
+	case reflect.Struct:
+		return encodeStruct(name, v)
+	default:
+		return nil, fmt.Errorf("%s: unsupported type: %s", name, v.Kind())
+	}
+}
+
+func encodeString(s string) *C.ucl_object_t {
+	cs := C.CString(s)
+	defer C.free(unsafe.Pointer(cs))
+
+	return C.ucl_object_fromstring_common(cs, C.size_t(len(s)), C.UCL_STRING_RAW)
+}
+
+// textMarshaler returns v as an encoding.TextMarshaler, or nil if it isn't
+// one. Nil pointers and interfaces are left to be encoded as null.
+func textMarshaler(v reflect.Value) encoding.TextMarshaler {
+	if v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface || !v.CanInterface() {
+		return nil
+	}
+
+	if v.Type().Implements(textMarshalerType) {
+		return v.Interface().(encoding.TextMarshaler)
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textMarshalerType) {
+		return v.Addr().Interface().(encoding.TextMarshaler)
+	}
+	return nil
+}
+
+// encodeDuration creates a time object, which is emitted as a number of
+// seconds.
+func encodeDuration(d time.Duration) *C.ucl_object_t {
+	obj := C.ucl_object_fromdouble(C.double(d.Seconds()))
+	obj._type = C.UCL_TIME
+	return obj
+}
+
+// encodeInsert encodes v and inserts it into the object top under key.
+func encodeInsert(name string, top *C.ucl_object_t, key string, v reflect.Value) error {
+	elt, err := encode(name, v)
+	if err != nil {
+		return err
+	}
+
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	C.ucl_object_insert_key(top, elt, ckey, C.size_t(len(key)), true)
+	return nil
+}
+
+func encodeMap(name string, v reflect.Value) (*C.ucl_object_t, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, fmt.Errorf("%s: map must have string keys", name)
+	}
+
+	// Sort the keys so the output is stable
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	result := C.ucl_object_typed_new(C.UCL_OBJECT)
+	for _, key := range keys {
+		fieldName := fmt.Sprintf("%s[%s]", name, key.String())
+		if err := encodeInsert(fieldName, result, key.String(), v.MapIndex(key)); err != nil {
+			C.ucl_object_unref(result)
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func encodeSlice(name string, v reflect.Value) (*C.ucl_object_t, error) {
+	result := C.ucl_object_typed_new(C.UCL_ARRAY)
+	for i := 0; i < v.Len(); i++ {
+		fieldName := fmt.Sprintf("%s[%d]", name, i)
+		elt, err := encode(fieldName, v.Index(i))
+		if err != nil {
+			C.ucl_object_unref(result)
+			return nil, err
+		}
+
+		C.ucl_array_append(result, elt)
+	}
+
+	return result, nil
+}
+
+func encodeStruct(name string, v reflect.Value) (*C.ucl_object_t, error) {
+	result := C.ucl_object_typed_new(C.UCL_OBJECT)
+	if err := encodeStructFields(name, result, v); err != nil {
+		C.ucl_object_unref(result)
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// encodeStructFields inserts all the fields of the struct v into top,
+// recursing into embedded structs that are squashed.
+func encodeStructFields(name string, top *C.ucl_object_t, v reflect.Value) error {
+	structType := v.Type()
+field_loop:
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i)
+		field := v.Field(i)
+
+		tagValue := fieldType.Tag.Get(tagName)
+		tagParts := strings.Split(tagValue, ",")
+
+		if fieldType.Anonymous {
+			fieldKind := fieldType.Type.Kind()
+			if fieldKind != reflect.Struct {
+				return fmt.Errorf(
+					"%s: unsupported type to struct: %s",
+					fieldType.Name, fieldKind)
+			}
+
+			for _, tag := range tagOptions(tagParts) {
+				if tag == "squash" {
+					if err := encodeStructFields(name, top, field); err != nil {
+						return err
+					}
+					continue field_loop
+				}
+			}
+		}
+
+		// Unexported fields can't be decoded, so don't encode them either.
+		if fieldType.PkgPath != "" {
+			continue field_loop
+		}
+
+		b64 := false
+		for _, tag := range tagOptions(tagParts) {
+			switch tag {
+			case "base64":
+				b64 = true
+			case "decodedFields", "key", "object", "unusedKeys":
+				// These are filled in by the decoder from the object
+				// itself, they aren't part of its contents.
+				continue field_loop
+			}
+		}
+
+		// Leave out empty references rather than writing nulls
+		switch field.Kind() {
+		case reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			if field.IsNil() {
+				continue field_loop
+			}
+		}
+
+		fieldName := fieldType.Name
+		if tagParts[0] != "" {
+			fieldName = tagParts[0]
+		}
+
+		qualifiedName := fieldName
+		if name != "" {
+			qualifiedName = fmt.Sprintf("%s.%s", name, fieldName)
+		}
+
+		if b64 && field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+			field = reflect.ValueOf(base64.StdEncoding.EncodeToString(field.Bytes()))
+		}
+
+		if err := encodeInsert(qualifiedName, top, fieldName, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
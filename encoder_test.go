@@ -0,0 +1,228 @@
+package libucl
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEncode_basic(t *testing.T) {
+	type Basic struct {
+		Bool  bool   `libucl:"bool"`
+		Str   string `libucl:"str"`
+		Num   int    `libucl:"num"`
+		Card  uint   `libucl:"card"`
+		Float float64
+	}
+
+	obj, err := Encode(Basic{
+		Bool:  true,
+		Str:   "bar",
+		Num:   -7,
+		Card:  29,
+		Float: 1.5,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	result, err := obj.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"bool":true,"str":"bar","num":-7,"card":29,"Float":1.5}`
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestEncode_nil(t *testing.T) {
+	obj, err := Encode(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	if obj.Type() != ObjectTypeNull {
+		t.Fatalf("bad: %#v", obj.Type())
+	}
+}
+
+func TestEncode_mapSorted(t *testing.T) {
+	result, err := Marshal(map[string]int{"b": 2, "c": 3, "a": 1}, EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"a":1,"b":2,"c":3}`
+	if string(result) != expected {
+		t.Fatalf("bad: %#v", string(result))
+	}
+}
+
+func TestEncode_mapNonStringKey(t *testing.T) {
+	if _, err := Encode(map[int]string{1: "foo"}); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestEncode_unsupported(t *testing.T) {
+	if _, err := Encode(make(chan int)); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestEncode_roundTrip(t *testing.T) {
+	type Nested struct {
+		Name string `libucl:",key"`
+		Foo  string `libucl:"foo"`
+	}
+
+	type Embedded struct {
+		Baz string `libucl:"baz"`
+	}
+
+	type Struct struct {
+		Embedded `libucl:",squash"`
+		Bar      []string          `libucl:"bar"`
+		Value    map[string]Nested `libucl:"value"`
+		Ptr      *Nested           `libucl:"ptr"`
+		Keys     []string          `libucl:",decodedFields"`
+	}
+
+	input := Struct{
+		Embedded: Embedded{Baz: "what"},
+		Bar:      []string{"foo", "bar"},
+		Value: map[string]Nested{
+			"foo": {Name: "foo", Foo: "bar"},
+		},
+	}
+
+	data, err := Marshal(input, EmitConfig)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := testParseString(t, string(data))
+	defer obj.Close()
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	result.Keys = nil
+
+	if !reflect.DeepEqual(result, input) {
+		t.Fatalf("bad: %#v\n\n%s", result, data)
+	}
+}
+
+func TestEncode_time(t *testing.T) {
+	type Struct struct {
+		Timeout time.Duration `libucl:"timeout"`
+		Created time.Time     `libucl:"created"`
+	}
+
+	input := Struct{
+		Timeout: 1500 * time.Millisecond,
+		Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	obj, err := Encode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	timeout := obj.Get("timeout")
+	defer timeout.Close()
+	if timeout.Type() != ObjectTypeTime {
+		t.Fatalf("bad: %s", timeout.Type())
+	}
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(result, input) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestEncode_textMarshaler(t *testing.T) {
+	input := struct {
+		IP  net.IP  `libucl:"ip"`
+		Ptr *net.IP `libucl:"ptr"`
+	}{IP: net.ParseIP("192.0.2.1")}
+
+	obj, err := Encode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	ip := obj.Get("ip")
+	defer ip.Close()
+	if ip.Type() != ObjectTypeString || ip.ToString() != "192.0.2.1" {
+		t.Fatalf("bad: %s %s", ip.Type(), ip.ToString())
+	}
+
+	if ptr := obj.Get("ptr"); ptr != nil {
+		ptr.Close()
+		t.Fatal("nil pointer should be left out")
+	}
+}
+
+func TestEncode_bytes(t *testing.T) {
+	type Struct struct {
+		Raw     []byte `libucl:"raw"`
+		Encoded []byte `libucl:"encoded,base64"`
+	}
+
+	input := Struct{Raw: []byte("hello"), Encoded: []byte{0, 1, 2}}
+
+	obj, err := Encode(input)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	if v, _ := obj.LookupString("raw", ""); v != "hello" {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v, _ := obj.LookupString("encoded", ""); v != "AAEC" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	var result Struct
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !reflect.DeepEqual(result, input) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestEncode_object(t *testing.T) {
+	inner := testParseString(t, "foo = bar;")
+	defer inner.Close()
+
+	obj, err := Encode(map[string]*Object{"inner": inner})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	result, err := obj.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"inner":{"foo":"bar"}}`
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+}
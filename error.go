@@ -0,0 +1,156 @@
+package libucl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// ParseErrorCode is a programmatic way to figure out what went wrong while
+// parsing a configuration.
+type ParseErrorCode int
+
+const (
+	// ParseErrorOK means nothing went wrong (no error)
+	ParseErrorOK ParseErrorCode = C.UCL_EOK
+	// ParseErrorSyntax means the input is not valid UCL
+	ParseErrorSyntax ParseErrorCode = C.UCL_ESYNTAX
+	// ParseErrorIO means the input could not be read
+	ParseErrorIO ParseErrorCode = C.UCL_EIO
+	// ParseErrorState means the parser reached an invalid state
+	ParseErrorState ParseErrorCode = C.UCL_ESTATE
+	// ParseErrorNested means the input is nested too deeply
+	ParseErrorNested ParseErrorCode = C.UCL_ENESTED
+	// ParseErrorUnpaired means a brace or bracket was left unpaired
+	ParseErrorUnpaired ParseErrorCode = C.UCL_EUNPAIRED
+	// ParseErrorMacro means a macro failed or could not be found
+	ParseErrorMacro ParseErrorCode = C.UCL_EMACRO
+	// ParseErrorInternal means libucl hit an internal error
+	ParseErrorInternal ParseErrorCode = C.UCL_EINTERNAL
+	// ParseErrorSSL means a signature check failed
+	ParseErrorSSL ParseErrorCode = C.UCL_ESSL
+	// ParseErrorMerge means objects could not be merged
+	ParseErrorMerge ParseErrorCode = C.UCL_EMERGE
+)
+
+// ParseError contains information on an error found while parsing a
+// configuration. It is returned by all of the Parser.Add* functions and can
+// be retrieved with errors.As.
+type ParseError struct {
+	// Source is the name of the file being parsed when the error occured,
+	// or the empty string if the data came from a string.
+	Source string
+	// Line is the 1-based line number of the error, or 0 if unknown.
+	Line int
+	// Column is the 1-based column number of the error, or 0 if unknown.
+	Column int
+	// Code is the libucl error code.
+	Code ParseErrorCode
+	// Message is the raw error message reported by libucl.
+	Message string
+
+	// data is the parsed input, if it didn't come from a file.
+	data []byte
+}
+
+// Error returns the raw libucl error message.
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// Snippet renders the offending line of the input with a caret pointing at
+// the column of the error, suitable for command-line output. It returns the
+// empty string if the line cannot be found.
+func (e *ParseError) Snippet() string {
+	if e.Line <= 0 {
+		return ""
+	}
+
+	data := e.data
+	if data == nil {
+		if e.Source == "" {
+			return ""
+		}
+
+		var err error
+		data, err = ioutil.ReadFile(e.Source)
+		if err != nil {
+			return ""
+		}
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	if e.Line > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(string(lines[e.Line-1]), "\r")
+
+	// Keep tabs in the padding so the caret lines up with the text above
+	col := e.Column - 1
+	if col < 0 {
+		col = 0
+	}
+	if col > len(line) {
+		col = len(line)
+	}
+	padding := []byte(line[:col])
+	for i, c := range padding {
+		if c != '\t' {
+			padding[i] = ' '
+		}
+	}
+
+	gutter := fmt.Sprintf("%d | ", e.Line)
+	return fmt.Sprintf("%s%s\n%*s| %s^",
+		gutter, line, len(gutter)-2, "", padding)
+}
+
+// parseError builds a ParseError from the current error state of the parser.
+// source is the file being parsed and data the input, if known.
+func (p *Parser) parseError(source string, data []byte) error {
+	// An include handler already knows where things went wrong, unless it
+	// was in the data being added
+	if p.includeErr != nil {
+		e := p.includeErr
+		p.includeErr = nil
+		if e.Source == "" {
+			e.Source = source
+			e.data = data
+		}
+		return e
+	}
+
+	// Anything else that went wrong takes the place of an unknown variable
+	p.variableErr("", nil)
+
+	e := p.newParseError(source, data)
+
+	// The error may have come from a file included by the one being parsed.
+	// libucl keeps the name of the last file it read, so it is only
+	// meaningful when parsing a file.
+	if source != "" {
+		if cur := C.ucl_parser_get_cur_file(p.parser); cur != nil {
+			if file := C.GoString(C._go_uchar_to_char(cur)); file != source {
+				e.Source = file
+				e.data = nil
+			}
+		}
+	}
+
+	return e
+}
+
+func (p *Parser) newParseError(source string, data []byte) *ParseError {
+	return &ParseError{
+		Source:  source,
+		Line:    int(C.ucl_parser_get_linenum(p.parser)),
+		Column:  int(C.ucl_parser_get_column(p.parser)),
+		Code:    ParseErrorCode(C.ucl_parser_get_error_code(p.parser)),
+		Message: C.GoString(C.ucl_parser_get_error(p.parser)),
+		data:    data,
+	}
+}
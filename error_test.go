@@ -0,0 +1,108 @@
+package libucl
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseError(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	err := p.AddString("foo = bar;\nbaz = {qux;\n")
+	if err == nil {
+		t.Fatal("should fail")
+	}
+
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "" {
+		t.Fatalf("bad: %#v", perr.Source)
+	}
+	if perr.Line < 2 {
+		t.Fatalf("bad: %d", perr.Line)
+	}
+	if perr.Code == ParseErrorOK {
+		t.Fatalf("bad: %d", perr.Code)
+	}
+	if perr.Error() != perr.Message {
+		t.Fatalf("bad: %#v", perr.Error())
+	}
+}
+
+func TestParseError_file(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.conf")
+	if err := ioutil.WriteFile(path, []byte("foo = bar;\nbaz = {qux;\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	err := p.AddFile(path)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != path {
+		t.Fatalf("bad: %#v, expected %#v", perr.Source, path)
+	}
+	if perr.Snippet() == "" {
+		t.Fatal("should have snippet")
+	}
+}
+
+func TestParseError_stringAfterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.conf")
+	if err := ioutil.WriteFile(path, []byte("foo = bar;\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := p.AddString("baz = {qux;\n")
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "" {
+		t.Fatalf("bad: %#v", perr.Source)
+	}
+	if perr.Snippet() == "" {
+		t.Fatal("should have snippet")
+	}
+}
+
+func TestParseErrorSnippet(t *testing.T) {
+	e := &ParseError{
+		Line:   2,
+		Column: 8,
+		data:   []byte("foo = bar;\n\tbaz = {qux;\n"),
+	}
+
+	expected := "2 | \tbaz = {qux;\n  | \t      ^"
+	if e.Snippet() != expected {
+		t.Fatalf("bad: %#v, expected: %#v", e.Snippet(), expected)
+	}
+}
+
+func TestParseErrorSnippet_unknownLine(t *testing.T) {
+	e := &ParseError{data: []byte("foo = bar;")}
+	if e.Snippet() != "" {
+		t.Fatalf("bad: %#v", e.Snippet())
+	}
+
+	e.Line = 3
+	if e.Snippet() != "" {
+		t.Fatalf("bad: %#v", e.Snippet())
+	}
+}
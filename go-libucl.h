@@ -4,6 +4,10 @@
 #include <ucl.h>
 #include <stdlib.h>
 #include <string.h>
+#include <stdio.h>
+#include <stdint.h>
+#include <math.h>
+#include <float.h>
 
 static inline char *_go_uchar_to_char(const unsigned char *c) {
     return (char *)c;
@@ -13,6 +17,15 @@ static inline unsigned char *_go_char_to_uchar(const char *c) {
   return (unsigned char *)c;
 }
 
+//-------------------------------------------------------------------
+// Helpers: Iterators
+//-------------------------------------------------------------------
+
+// ucl_object_iterate_safe may be a macro, which cgo can't call.
+static inline const ucl_object_t *_go_object_iterate_safe(ucl_object_iter_t it, bool expand) {
+    return ucl_object_iterate_safe(it, expand);
+}
+
 //-------------------------------------------------------------------
 // Helpers: Macros
 //-------------------------------------------------------------------
@@ -32,12 +45,99 @@ static inline ucl_macro_handler _go_macro_handler_func() {
 	return (ucl_macro_handler)&_go_macro_handler;
 }
 
+// Takes the chunk that was added last off the parser's stack, as libucl does
+// after parsing one of its own includes. There's no function for that, but
+// ucl_parser_insert_chunk always removes the chunk on top, even when it
+// refuses the data it's given, so it's given none. That isn't documented:
+// it is how ucl_parser_insert_chunk works as of libucl 0.8.1, and needs
+// checking again for other versions.
+static inline void _go_parser_pop_chunk(struct ucl_parser *parser) {
+    ucl_parser_insert_chunk(parser, NULL, 1);
+    ucl_parser_clear_error(parser);
+}
+
 // This just converts an int to a void*, because Go doesn't let us do that
-// and we use an int as the user data for registering macros.
+// and we use an int as the user data for registering macros and variable
+// handlers.
 static inline void *_go_macro_index(int idx) {
     return (void *)(intptr_t)idx;
 }
 
+//-------------------------------------------------------------------
+// Helpers: Variables
+//-------------------------------------------------------------------
+
+// This is declared in variable.go and invokes the Go variable handler of
+// the parser registered under the given ID.
+extern bool go_variable_call(int idx, unsigned char *data, size_t len, unsigned char **replace, size_t *replace_len, bool *need_free);
+
+static inline bool _go_variable_handler(const unsigned char *data, size_t len, unsigned char **replace, size_t *replace_len, bool *need_free, void* ud) {
+    return go_variable_call((intptr_t)ud, (unsigned char *)data, len, replace, replace_len, need_free);
+}
+
+// Returns the ucl_variable_handler that we have, since we can't get this
+// type from cgo.
+static inline ucl_variable_handler _go_variable_handler_func() {
+	return (ucl_variable_handler)&_go_variable_handler;
+}
+
+//-------------------------------------------------------------------
+// Helpers: Emitters
+//-------------------------------------------------------------------
+
+// These are declared in emit.go and write the output of an emitter to the
+// Go writer registered under the given ID.
+extern int go_emit_append_character(int idx, unsigned char c, size_t nchars);
+extern int go_emit_append_len(int idx, unsigned char *str, size_t len);
+
+static inline int _go_emit_append_character(unsigned char c, size_t nchars, void *ud) {
+    return go_emit_append_character((intptr_t)ud, c, nchars);
+}
+
+static inline int _go_emit_append_len(const unsigned char *str, size_t len, void *ud) {
+    return go_emit_append_len((intptr_t)ud, (unsigned char *)str, len);
+}
+
+static inline int _go_emit_append_int(int64_t elt, void *ud) {
+    char buf[32];
+    int n = snprintf(buf, sizeof(buf), "%jd", (intmax_t)elt);
+    return go_emit_append_len((intptr_t)ud, (unsigned char *)buf, n);
+}
+
+// Formats doubles the same way as the built-in libucl emitters.
+static inline int _go_emit_append_double(double val, void *ud) {
+    char buf[512];
+    const double delta = 0.0000001;
+    int n;
+
+    if (val == (double)(int)val) {
+        n = snprintf(buf, sizeof(buf), "%.1lf", val);
+    } else if (fabs(val - (double)(int)val) < delta) {
+        n = snprintf(buf, sizeof(buf), "%.*lg", DBL_DIG, val);
+    } else {
+        n = snprintf(buf, sizeof(buf), "%lf", val);
+    }
+    if (n >= (int)sizeof(buf)) {
+        n = sizeof(buf) - 1;
+    }
+
+    return go_emit_append_len((intptr_t)ud, (unsigned char *)buf, n);
+}
+
+// Emits an object through the Go writer registered under the given ID.
+static inline bool _go_emit_full(const ucl_object_t *obj, enum ucl_emitter type, int idx) {
+    struct ucl_emitter_functions funcs = {
+        .ucl_emitter_append_character = _go_emit_append_character,
+        .ucl_emitter_append_len = _go_emit_append_len,
+        .ucl_emitter_append_int = _go_emit_append_int,
+        .ucl_emitter_append_double = _go_emit_append_double,
+        .ucl_emitter_free_func = NULL,
+        .ud = (void *)(intptr_t)idx,
+    };
+
+    return ucl_object_emit_full(obj, type, &funcs, NULL);
+}
+
 typedef struct ucl_schema_error ucl_schema_error_t;
 
 #endif /* _GOLIBUCL_H_INCLUDED */
@@ -2,4 +2,8 @@ module github.com/rjp/go-libucl
 
 replace github.com/bitmark-inc/go-libucl => github.com/rjp/go-libucl v0.12.0
 
-go 1.14
+go 1.21
+
+require github.com/fsnotify/fsnotify v1.6.0
+
+require golang.org/x/sys v0.0.0-20220908164124-27713097b956 // indirect
@@ -0,0 +1,210 @@
+package libucl
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// IncludeFile is a single file to be parsed for an .include directive.
+type IncludeFile struct {
+	// Name is used in error messages.
+	Name string
+	// Data is the UCL contents of the file.
+	Data []byte
+}
+
+// IncludeHandler is the callback type for resolving .include directives. It
+// is given the path from the directive, after variable expansion, and
+// whether the path is a pattern, which it is if it contains any of the
+// characters "*?[" or the directive has `glob=true`. It returns the files to
+// parse in order.
+//
+// If nothing matches the path, the handler should return an error wrapping
+// fs.ErrNotExist, so that it can be ignored by `.try_include` and
+// `.include(try=true)`.
+type IncludeHandler func(path string, glob bool) ([]IncludeFile, error)
+
+// SetIncludeFS makes the parser resolve .include directives against fsys
+// instead of the real filesystem. Paths are relative to the root of fsys,
+// and may be patterns, such as "conf.d/*.conf", in which case the matching
+// files are included in lexical order.
+func (p *Parser) SetIncludeFS(fsys fs.FS) {
+	p.SetIncludeHandler(func(path string, glob bool) ([]IncludeFile, error) {
+		return includeFS(fsys, path, glob)
+	})
+}
+
+// SetIncludeHandler makes the parser resolve .include and .try_include
+// directives by calling h. The `try`, `glob`, `priority` and `duplicate`
+// parameters of the directive are honoured.
+func (p *Parser) SetIncludeHandler(h IncludeHandler) {
+	// The macros read the handler when they're called, so they only need
+	// registering once.
+	if p.include == nil {
+		p.registerMacro("include", macro{
+			f: func(args Object, body string) bool {
+				return p.includeMacro(args, body, false)
+			},
+			strict: true,
+		})
+		p.registerMacro("try_include", macro{
+			f: func(args Object, body string) bool {
+				return p.includeMacro(args, body, true)
+			},
+			strict: true,
+		})
+	}
+
+	p.include = h
+}
+
+func (p *Parser) includeMacro(args Object, body string, try bool) bool {
+	priority := uint(C.ucl_parser_get_default_priority(p.parser))
+	strategy := DuplicateAppend
+	glob := false
+
+	if v := args.Get("try"); v != nil {
+		try = v.ToBool()
+		v.Close()
+	}
+	if v := args.Get("glob"); v != nil {
+		glob = v.ToBool()
+		v.Close()
+	}
+	if v := args.Get("priority"); v != nil {
+		n := v.ToInt()
+		v.Close()
+
+		if n < 0 || n > maxPriority {
+			p.setIncludeErr(fmt.Sprintf("invalid include priority: %d", n))
+			return false
+		}
+		priority = uint(n)
+	}
+	if v := args.Get("duplicate"); v != nil {
+		name := v.ToString()
+		v.Close()
+
+		switch strings.ToLower(name) {
+		case "append":
+			strategy = DuplicateAppend
+		case "merge":
+			strategy = DuplicateMerge
+		case "rewrite":
+			strategy = DuplicateRewrite
+		case "error":
+			strategy = DuplicateError
+		default:
+			p.setIncludeErr(fmt.Sprintf(
+				"unknown include duplicate strategy: %s", name))
+			return false
+		}
+	}
+
+	// Paths with glob characters are patterns, as they are for libucl's
+	// own includes
+	if strings.ContainsAny(body, "*?[") {
+		glob = true
+	}
+
+	files, err := p.include(body, glob)
+	if err != nil {
+		if try && errors.Is(err, fs.ErrNotExist) {
+			return true
+		}
+
+		p.setIncludeErr(fmt.Sprintf("cannot include %s: %s", body, err))
+		return false
+	}
+
+	for _, f := range files {
+		if !p.includeChunk(f, priority, strategy) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// includeChunk parses an included file where the directive is, and then
+// takes it off libucl's stack of chunks, so that the rest of the including
+// chunk is parsed as it was before.
+func (p *Parser) includeChunk(f IncludeFile, priority uint, strategy DuplicateStrategy) bool {
+	parent := p.includeFile
+	p.includeFile = &f
+	if p.variables != nil {
+		p.variables.include = &f
+	}
+	defer func() {
+		p.includeFile = parent
+		if p.variables != nil {
+			p.variables.include = parent
+		}
+	}()
+
+	if !p.parseChunk(f.Data, priority, strategy, ParseUCL) {
+		// Only keep the innermost error for nested includes
+		if p.includeErr == nil {
+			p.includeErr = p.newParseError(f.Name, f.Data)
+		}
+		return false
+	}
+
+	C._go_parser_pop_chunk(p.parser)
+	return true
+}
+
+// setIncludeErr records an error found while resolving an include, at the
+// position of the directive. The source is filled in by parseError if the
+// directive isn't in an included file.
+func (p *Parser) setIncludeErr(msg string) {
+	if p.includeErr != nil {
+		return
+	}
+
+	if f := p.includeFile; f != nil {
+		p.includeErr = p.newParseError(f.Name, f.Data)
+	} else {
+		p.includeErr = p.newParseError("", nil)
+	}
+	p.includeErr.Code = ParseErrorIO
+	p.includeErr.Message = msg
+}
+
+func includeFS(fsys fs.FS, pattern string, glob bool) ([]IncludeFile, error) {
+	pattern = strings.TrimPrefix(path.Clean(pattern), "/")
+
+	if !glob {
+		data, err := fs.ReadFile(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		return []IncludeFile{{Name: pattern, Data: data}}, nil
+	}
+
+	matches, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no files match: %w", fs.ErrNotExist)
+	}
+
+	files := make([]IncludeFile, 0, len(matches))
+	for _, name := range matches {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, IncludeFile{Name: name, Data: data})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,277 @@
+package libucl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func testIncludeFS() fstest.MapFS {
+	return fstest.MapFS{
+		"main.conf":        {Data: []byte(`port = 80; .include "conf.d/*.conf"`)},
+		"conf.d/a.conf":    {Data: []byte(`a = 1;`)},
+		"conf.d/b.conf":    {Data: []byte(`b = 2; .include "nested.conf"`)},
+		"nested.conf":      {Data: []byte(`nested = true;`)},
+		"override.conf":    {Data: []byte(`port = 8080;`)},
+		"broken/bad.conf":  {Data: []byte("ok = 1;\nbad = {;\n")},
+		"empty/README.txt": {Data: []byte(`nothing here`)},
+		"missing/inc.conf": {Data: []byte("ok = 1;\n.include \"nope.conf\"\n")},
+	}
+}
+
+func TestParserSetIncludeFS(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	if err := p.AddString(`.include "main.conf"`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	var result map[string]interface{}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"port":   80,
+		"a":      1,
+		"b":      2,
+		"nested": true,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestParserSetIncludeFS_nestedObject(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	config := `a { before = 1; .include "nested.conf"; after = 2; } b { c = 3; } d = 4;`
+	if err := p.AddString(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	// The included keys land in the object around the directive, and the
+	// objects after it are closed where they were.
+	if v, _ := obj.LookupBool("a.nested", false); !v {
+		t.Fatal("a.nested should be set")
+	}
+	for path, expected := range map[string]int64{"a.before": 1, "a.after": 2, "b.c": 3, "d": 4} {
+		if v, _ := obj.LookupInt(path, 0); v != expected {
+			t.Fatalf("%s: bad: %#v", path, v)
+		}
+	}
+	for _, key := range []string{"nested", "after", "c"} {
+		if v := obj.Get(key); v != nil {
+			v.Close()
+			t.Fatalf("%s should not be at the root", key)
+		}
+	}
+	if n := obj.Len(); n != 3 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestParserSetIncludeFS_priority(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	config := `port = 80; .include(priority=5) "override.conf"`
+	if err := p.AddString(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	port := obj.Get("port")
+	if port == nil {
+		t.Fatal("should find")
+	}
+	defer port.Close()
+	if port.Len() != 1 || port.ToInt() != 8080 {
+		t.Fatalf("bad: %d", port.ToInt())
+	}
+	if port.Priority() != 5 {
+		t.Fatalf("bad: %d", port.Priority())
+	}
+}
+
+func TestParserSetIncludeFS_duplicate(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	config := `port = 80; .include(duplicate="error") "override.conf"`
+	if err := p.AddString(config); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestParserSetIncludeFS_missing(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	err := p.AddString(`.include "missing.conf"`)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Code != ParseErrorIO {
+		t.Fatalf("bad: %d", perr.Code)
+	}
+}
+
+func TestParserSetIncludeFS_try(t *testing.T) {
+	configs := []string{
+		`.include(try=true) "missing.conf"; foo = bar;`,
+		`.try_include "missing.conf"; foo = bar;`,
+		`.try_include(glob=true) "empty/*.conf"; foo = bar;`,
+		`.try_include "conf.d/*.conf"; foo = bar;`,
+	}
+
+	for _, config := range configs {
+		p := NewParser(0)
+		p.SetIncludeFS(testIncludeFS())
+
+		if err := p.AddString(config); err != nil {
+			t.Fatalf("%s: err: %s", config, err)
+		}
+		p.Close()
+	}
+}
+
+func TestParserSetIncludeFS_parseError(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	err := p.AddString("foo = bar;\n.include(glob=true) \"broken/*.conf\"\n")
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "broken/bad.conf" {
+		t.Fatalf("bad: %#v", perr.Source)
+	}
+	if perr.Line < 2 {
+		t.Fatalf("bad: %d", perr.Line)
+	}
+}
+
+func TestParserSetIncludeHandler(t *testing.T) {
+	var paths []string
+	handler := func(path string, glob bool) ([]IncludeFile, error) {
+		if glob {
+			t.Fatal("should not glob")
+		}
+		paths = append(paths, path)
+		return []IncludeFile{{Name: path, Data: []byte(`foo = bar;`)}}, nil
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeHandler(handler)
+
+	if err := p.AddString(`.include "virtual.conf"`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !reflect.DeepEqual(paths, []string{"virtual.conf"}) {
+		t.Fatalf("bad: %#v", paths)
+	}
+}
+
+func TestParserSetIncludeFS_invalidPriority(t *testing.T) {
+	for _, priority := range []int{-1, 16} {
+		p := NewParser(0)
+		p.SetIncludeFS(testIncludeFS())
+
+		config := fmt.Sprintf(`.include(priority=%d) "override.conf"`, priority)
+		err := p.AddString(config)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%d: bad: %#v", priority, err)
+		}
+		if perr.Code != ParseErrorIO {
+			t.Fatalf("%d: bad: %d", priority, perr.Code)
+		}
+		p.Close()
+	}
+}
+
+func TestParserSetIncludeFS_errorSource(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	err := p.AddString(`.include "missing/inc.conf"`)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "missing/inc.conf" || perr.Line != 2 {
+		t.Fatalf("bad: %#v", perr)
+	}
+
+	p = NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(testIncludeFS())
+
+	err = p.AddString("foo = bar;\n.include \"nope.conf\"\n")
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "" || perr.Line != 2 || perr.Snippet() == "" {
+		t.Fatalf("bad: %#v", perr)
+	}
+}
+
+func TestParserSetIncludeFS_many(t *testing.T) {
+	// libucl limits how deeply chunks can be nested, which includes must
+	// not count towards once they have been parsed
+	fsys := fstest.MapFS{}
+	config := ""
+	for i := 0; i < 32; i++ {
+		name := fmt.Sprintf("conf.d/%02d.conf", i)
+		fsys[name] = &fstest.MapFile{Data: []byte(fmt.Sprintf("key%d = %d;", i, i))}
+		config += fmt.Sprintf(".include \"%s\"\n", name)
+	}
+	config += "last = {;\n"
+
+	p := NewParser(0)
+	defer p.Close()
+	p.SetIncludeFS(fsys)
+
+	// The error is reported in the including data, after the includes
+	err := p.AddString(config)
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "" || perr.Line != 33 {
+		t.Fatalf("bad: %#v", perr)
+	}
+}
@@ -0,0 +1,73 @@
+//go:build libucl_leakcheck
+// +build libucl_leakcheck
+
+package libucl
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Every value that has been created but not closed, by the ID it was given.
+// IDs increase, so they're also the order the values were created in.
+var leaks = make(map[uint64]Leak)
+var leaksIdx uint64
+var leaksLock sync.Mutex
+
+// Leaks returns every Object, ObjectIter and Parser that has been created
+// but not closed yet, in the order they were created. Values are only
+// tracked when built with the libucl_leakcheck build tag, which is meant for
+// tests:
+//
+//	go test -tags libucl_leakcheck ./...
+func Leaks() []Leak {
+	leaksLock.Lock()
+	defer leaksLock.Unlock()
+
+	ids := make([]uint64, 0, len(leaks))
+	for id := range leaks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+
+	var result []Leak
+	for _, id := range ids {
+		result = append(result, leaks[id])
+	}
+	return result
+}
+
+// trackAlloc starts tracking a new value and returns its ID, which is never
+// 0.
+func trackAlloc(kind string) uint64 {
+	// Skip runtime.Callers, trackAlloc and the constructor
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&stack, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	leaksLock.Lock()
+	defer leaksLock.Unlock()
+	leaksIdx++
+	leaks[leaksIdx] = Leak{Kind: kind, Stack: stack.String()}
+	return leaksIdx
+}
+
+func trackRelease(id uint64) {
+	leaksLock.Lock()
+	defer leaksLock.Unlock()
+	delete(leaks, id)
+}
@@ -0,0 +1,20 @@
+//go:build !libucl_leakcheck
+// +build !libucl_leakcheck
+
+package libucl
+
+// Leaks returns every Object, ObjectIter and Parser that has been created
+// but not closed yet, in the order they were created. Values are only
+// tracked when built with the libucl_leakcheck build tag, which is meant for
+// tests:
+//
+//	go test -tags libucl_leakcheck ./...
+func Leaks() []Leak {
+	return nil
+}
+
+func trackAlloc(kind string) uint64 {
+	return 0
+}
+
+func trackRelease(id uint64) {}
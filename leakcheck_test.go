@@ -0,0 +1,55 @@
+//go:build libucl_leakcheck
+// +build libucl_leakcheck
+
+package libucl
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLeaks(t *testing.T) {
+	before := len(Leaks())
+
+	obj := NewObject("foo")
+	leaks := Leaks()
+	if len(leaks) != before+1 {
+		t.Fatalf("bad: %#v", leaks)
+	}
+
+	leak := leaks[len(leaks)-1]
+	if leak.Kind != "Object" {
+		t.Fatalf("bad: %#v", leak.Kind)
+	}
+	if !strings.Contains(leak.Stack, "TestLeaks") {
+		t.Fatalf("bad: %s", leak.Stack)
+	}
+
+	obj.Close()
+	if len(Leaks()) != before {
+		t.Fatalf("bad: %#v", Leaks())
+	}
+}
+
+func TestLeaks_autoClose(t *testing.T) {
+	SetAutoClose(true)
+	defer SetAutoClose(false)
+
+	before := len(Leaks())
+	NewObject("foo")
+	if len(Leaks()) != before+1 {
+		t.Fatalf("bad: %#v", Leaks())
+	}
+
+	// Finalizers run in the background, so give them a chance
+	for i := 0; i < 10 && len(Leaks()) > before; i++ {
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if len(Leaks()) != before {
+		t.Fatalf("bad: %#v", Leaks())
+	}
+}
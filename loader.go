@@ -0,0 +1,321 @@
+package libucl
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Loader builds a single configuration from several sources, such as built-in
+// defaults, a system file, a user file, a conf.d directory, environment
+// variables and command-line flags.
+//
+// Sources are given in order of increasing priority, so values from sources
+// that are added later override those from earlier ones. Each file and
+// other source is parsed on its own, then objects from different sources
+// are merged key by key, while arrays and other values replace those from
+// earlier sources.
+//
+// The merge is done in Go rather than with libucl priorities, since libucl
+// appends arrays and keeps the first scalar when priorities are equal. As a
+// result Object.Priority doesn't report which source a value came from; use
+// Source for that.
+type Loader struct {
+	// Flags are passed to NewParser when loading.
+	Flags ParserFlag
+
+	sources []loaderSource
+	origins map[string]string
+}
+
+// loaderSource is a source added to a Loader, which expands into any number
+// of chunks to parse when the configuration is loaded.
+type loaderSource func() ([]loaderChunk, error)
+
+type loaderChunk struct {
+	name string
+	path string
+	data []byte
+}
+
+// AddDefaults adds a Go value holding default settings, encoded with Encode.
+func (l *Loader) AddDefaults(v interface{}) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		data, err := Marshal(v, EmitJSONCompact)
+		if err != nil {
+			return nil, err
+		}
+		return []loaderChunk{{name: "defaults", data: data}}, nil
+	})
+}
+
+// AddString adds UCL data, identified by name in Source.
+func (l *Loader) AddString(name, data string) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		return []loaderChunk{{name: name, data: []byte(data)}}, nil
+	})
+}
+
+// AddFile adds a configuration file. The file is skipped if it doesn't
+// exist.
+func (l *Loader) AddFile(path string) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return []loaderChunk{{name: path, path: path}}, nil
+	})
+}
+
+// AddDir adds every file in dir with a .conf extension, in lexical order so
+// that later files override earlier ones. Nothing is added if the directory
+// doesn't exist.
+func (l *Loader) AddDir(dir string) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(paths)
+
+		chunks := make([]loaderChunk, 0, len(paths))
+		for _, path := range paths {
+			chunks = append(chunks, loaderChunk{name: path, path: path})
+		}
+		return chunks, nil
+	})
+}
+
+// AddEnv adds the environment variables that start with prefix. The rest of
+// the name is lowercased and split into nested keys on double underscores,
+// so with the prefix "APP_", APP_SERVER__PORT sets server.port and
+// APP_LOG_LEVEL sets log_level. The values are strings, which Decode
+// converts to the type of the field they are decoded into.
+func (l *Loader) AddEnv(prefix string) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		values := make(map[string]string)
+		for _, kv := range os.Environ() {
+			parts := strings.SplitN(kv, "=", 2)
+			if len(parts) != 2 || !strings.HasPrefix(parts[0], prefix) {
+				continue
+			}
+
+			name := strings.ToLower(strings.TrimPrefix(parts[0], prefix))
+			if name == "" {
+				continue
+			}
+			values[strings.Replace(name, "__", ".", -1)] = parts[1]
+		}
+
+		return loaderValues("env", values)
+	})
+}
+
+// AddFlags adds the flags that were set on the command line. Flag
+// names are split into nested keys on dots, so -server.port sets
+// server.port. The flags must have been parsed before the configuration is
+// loaded.
+func (l *Loader) AddFlags(flags *flag.FlagSet) {
+	l.sources = append(l.sources, func() ([]loaderChunk, error) {
+		values := make(map[string]string)
+		flags.Visit(func(f *flag.Flag) {
+			values[f.Name] = f.Value.String()
+		})
+
+		return loaderValues("flags", values)
+	})
+}
+
+// loaderValues turns the values of dotted keys into a chunk of nested
+// objects.
+func loaderValues(name string, values map[string]string) ([]loaderChunk, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	// Sort the keys so the result doesn't depend on map order. Nested keys
+	// such as "server.port" sort last and replace a value for "server".
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	root := make(map[string]interface{})
+	for _, k := range keys {
+		parts := strings.Split(k, ".")
+
+		m := root
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := m[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				m[part] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = values[k]
+	}
+
+	data, err := Marshal(root, EmitJSONCompact)
+	if err != nil {
+		return nil, err
+	}
+	return []loaderChunk{{name: name, data: data}}, nil
+}
+
+// Load parses all the sources into a single object. The returned object
+// must be closed when you're done using it.
+func (l *Loader) Load() (*Object, error) {
+	result := NewObjectMap()
+	origins := make(map[string]string)
+
+	for _, source := range l.sources {
+		chunks, err := source()
+		if err != nil {
+			result.Close()
+			return nil, err
+		}
+
+		for _, c := range chunks {
+			obj, err := l.parse(c)
+			if err != nil {
+				result.Close()
+				return nil, err
+			}
+
+			loaderMerge(result, obj, "", c.name, origins)
+			obj.Close()
+		}
+	}
+
+	l.origins = origins
+	return result, nil
+}
+
+// parse parses a single chunk on its own.
+func (l *Loader) parse(c loaderChunk) (*Object, error) {
+	p := NewParser(l.Flags)
+	defer p.Close()
+
+	var err error
+	if c.path != "" {
+		err = p.AddFile(c.path)
+	} else {
+		err = p.AddBytes(c.data)
+	}
+	if err != nil {
+		var perr *ParseError
+		if errors.As(err, &perr) && perr.Source == "" {
+			perr.Source = c.name
+		}
+		return nil, err
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		return NewObjectMap(), nil
+	}
+	if obj.Type() != ObjectTypeObject {
+		obj.Close()
+		return nil, fmt.Errorf("%s: configuration must be an object", c.name)
+	}
+	return obj, nil
+}
+
+// Decode loads the configuration and decodes it into v, as Object.Decode.
+func (l *Loader) Decode(v interface{}) error {
+	obj, err := l.Load()
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	return obj.Decode(v)
+}
+
+// Source returns the name of the source that the value at the given path
+// came from in the last configuration loaded, or the empty string if there
+// is no such value. The path is in the same format as for Lookup, and must
+// be a value rather than an object. Files are named by their path, and the
+// other sources are "defaults", "env", "flags" or the name given to
+// AddString.
+func (l *Loader) Source(path string) string {
+	return l.origins[path]
+}
+
+// loaderMerge merges src, which came from the source called name, into dst.
+// Objects are merged key by key, and anything else in src replaces what is
+// in dst. The values are moved out of src rather than copied.
+func loaderMerge(dst, src *Object, path, name string, origins map[string]string) {
+	var keys []string
+	iter := src.Iterate(true)
+	for elt := iter.Next(); elt != nil; elt = iter.Next() {
+		keys = append(keys, elt.Key())
+		elt.Close()
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		value := src.PopKey(key)
+		if value == nil {
+			continue
+		}
+
+		keyPath := loaderPath(path, key)
+		existing := dst.Get(key)
+		if existing != nil && loaderIsObject(existing) && loaderIsObject(value) {
+			loaderMerge(existing, value, keyPath, name, origins)
+		} else {
+			for p := range origins {
+				if p == keyPath || strings.HasPrefix(p, keyPath+".") {
+					delete(origins, p)
+				}
+			}
+			loaderOrigins(value, keyPath, name, origins)
+			dst.Set(key, value)
+		}
+
+		if existing != nil {
+			existing.Close()
+		}
+		value.Close()
+	}
+}
+
+// loaderOrigins records name as the source of every value in obj.
+func loaderOrigins(obj *Object, path, name string, origins map[string]string) {
+	if !loaderIsObject(obj) {
+		origins[path] = name
+		return
+	}
+
+	iter := obj.Iterate(true)
+	defer iter.Close()
+	for elt := iter.Next(); elt != nil; elt = iter.Next() {
+		loaderOrigins(elt, loaderPath(path, elt.Key()), name, origins)
+		elt.Close()
+	}
+}
+
+// loaderIsObject returns whether obj is a single object, rather than a
+// value or the implicit array of a repeated key.
+func loaderIsObject(obj *Object) bool {
+	defer runtime.KeepAlive(obj)
+	return obj.Type() == ObjectTypeObject && obj.object.next == nil
+}
+
+// loaderPath returns the Lookup path of key within path.
+func loaderPath(path, key string) string {
+	key = strings.NewReplacer(`\`, `\\`, `.`, `\.`).Replace(key)
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,202 @@
+package libucl
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testLoaderConfig struct {
+	Name   string `libucl:"name"`
+	Server struct {
+		Host string `libucl:"host"`
+		Port int    `libucl:"port"`
+	} `libucl:"server"`
+	LogLevel string `libucl:"log_level"`
+	Debug    bool   `libucl:"debug"`
+}
+
+func TestLoader(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"system.conf":      `name = "system"; server { host = "0.0.0.0"; port = 80; }`,
+		"conf.d/10-a.conf": `log_level = "info";`,
+		"conf.d/20-b.conf": `log_level = "warn";`,
+	})
+
+	defaults := testLoaderConfig{Name: "default", LogLevel: "error"}
+	defaults.Server.Port = 8080
+
+	os.Setenv("TESTAPP_SERVER__PORT", "9090")
+	defer os.Unsetenv("TESTAPP_SERVER__PORT")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("debug", false, "")
+	flags.String("name", "", "")
+	if err := flags.Parse([]string{"-debug"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var l Loader
+	l.AddDefaults(defaults)
+	l.AddFile(filepath.Join(dir, "system.conf"))
+	l.AddFile(filepath.Join(dir, "missing.conf"))
+	l.AddDir(filepath.Join(dir, "conf.d"))
+	l.AddEnv("TESTAPP_")
+	l.AddFlags(flags)
+
+	var result testLoaderConfig
+	if err := l.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := testLoaderConfig{Name: "system", LogLevel: "warn", Debug: true}
+	expected.Server.Host = "0.0.0.0"
+	expected.Server.Port = 9090
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	sources := map[string]string{
+		"name":        filepath.Join(dir, "system.conf"),
+		"server.host": filepath.Join(dir, "system.conf"),
+		"server.port": "env",
+		"log_level":   filepath.Join(dir, "conf.d/20-b.conf"),
+		"debug":       "flags",
+		"missing":     "",
+	}
+	for path, source := range sources {
+		if actual := l.Source(path); actual != source {
+			t.Fatalf("%s: bad: %#v", path, actual)
+		}
+	}
+}
+
+func TestLoader_defaultsKept(t *testing.T) {
+	var l Loader
+	l.AddString("base", `server { host = "localhost"; port = 80; }`)
+	l.AddString("override", `server { port = 8080; }`)
+
+	obj, err := l.Load()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	if v, _ := obj.LookupString("server.host", ""); v != "localhost" {
+		t.Fatalf("bad: %#v", v)
+	}
+	if v, _ := obj.LookupInt("server.port", 0); v != 8080 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if s := l.Source("server.host"); s != "base" {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+func TestLoader_parseError(t *testing.T) {
+	var l Loader
+	l.AddString("broken", `server {`)
+
+	_, err := l.Load()
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "broken" {
+		t.Fatalf("bad: %#v", perr.Source)
+	}
+}
+
+func TestLoader_arrays(t *testing.T) {
+	var l Loader
+	l.AddDefaults(map[string]interface{}{
+		"listen": []string{"a"},
+		"server": map[string]interface{}{"tags": []string{"x", "y"}},
+	})
+	l.AddString("user", `listen = ["b"]; server { tags = ["z"]; }`)
+
+	obj, err := l.Load()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	var result map[string]interface{}
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"listen": []interface{}{"b"},
+		"server": map[string]interface{}{"tags": []interface{}{"z"}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+	if s := l.Source("listen"); s != "user" {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+func TestLoader_manySources(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("conf.d/%02d.conf", i)] = fmt.Sprintf("n = %d;", i)
+	}
+	dir := testWatchDir(t, files)
+
+	var l Loader
+	l.AddString("base", `n = -1; base = true;`)
+	l.AddDir(filepath.Join(dir, "conf.d"))
+
+	obj, err := l.Load()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	if v, _ := obj.LookupInt("n", 0); v != 19 {
+		t.Fatalf("bad: %#v", v)
+	}
+	if s := l.Source("n"); s != filepath.Join(dir, "conf.d/19.conf") {
+		t.Fatalf("bad: %#v", s)
+	}
+	if s := l.Source("base"); s != "base" {
+		t.Fatalf("bad: %#v", s)
+	}
+}
+
+func TestLoader_nestedSource(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"app.conf": `database { primary { host = "db1"; port = 5432; } }`,
+	})
+
+	var l Loader
+	l.AddString("base", `name = "app";`)
+	l.AddFile(filepath.Join(dir, "app.conf"))
+
+	obj, err := l.Load()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer obj.Close()
+
+	if v, _ := obj.LookupString("database.primary.host", ""); v != "db1" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	sources := map[string]string{
+		"name":                  "base",
+		"database.primary.host": filepath.Join(dir, "app.conf"),
+		"database.primary.port": filepath.Join(dir, "app.conf"),
+		"database.primary":      "",
+	}
+	for path, source := range sources {
+		if actual := l.Source(path); actual != source {
+			t.Fatalf("%s: bad: %#v", path, actual)
+		}
+	}
+}
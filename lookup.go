@@ -0,0 +1,176 @@
+package libucl
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+var (
+	// ErrPathNotFound is returned by the typed Lookup functions when there is
+	// no value at the given path.
+	ErrPathNotFound = errors.New("path not found")
+
+	// ErrWrongType is returned by the typed Lookup functions when the value at
+	// the given path is not of the requested type.
+	ErrWrongType = errors.New("wrong type")
+)
+
+// Lookup returns the element at the given dot-separated path, such as
+// "server.tls.cert", or nil if there is no such element.
+//
+// Numeric path components index into arrays, including implicit arrays
+// created by repeating a key, so "listeners.0.port" is the port of the
+// first listener. Keys that contain dots can be matched by escaping the
+// dot with a backslash, as in "hosts.example\.com.port"; a literal
+// backslash is written as two backslashes.
+//
+// The returned object must be closed when you're done using it.
+func (o *Object) Lookup(path string) *Object {
+	defer runtime.KeepAlive(o)
+	// Let libucl handle the simple cases
+	if !strings.Contains(path, `\`) {
+		cpath := C.CString(path)
+		defer C.free(unsafe.Pointer(cpath))
+
+		if obj := C.ucl_object_lookup_path(o.object, cpath); obj != nil {
+			result := newObject(obj)
+			result.Ref()
+			return result
+		}
+	}
+
+	obj := o.object
+	for _, part := range splitPath(path) {
+		obj = lookupPart(obj, part)
+		if obj == nil {
+			return nil
+		}
+	}
+
+	result := newObject(obj)
+	result.Ref()
+	return result
+}
+
+// LookupString returns the string at the given path. If there is no string
+// at the path, def is returned along with an error wrapping either
+// ErrPathNotFound or ErrWrongType.
+func (o *Object) LookupString(path string, def string) (string, error) {
+	obj, err := o.lookupType(path, ObjectTypeString)
+	if err != nil {
+		return def, err
+	}
+	defer obj.Close()
+
+	return obj.ToString(), nil
+}
+
+// LookupInt returns the integer at the given path. If there is no integer
+// at the path, def is returned along with an error wrapping either
+// ErrPathNotFound or ErrWrongType.
+func (o *Object) LookupInt(path string, def int64) (int64, error) {
+	obj, err := o.lookupType(path, ObjectTypeInt)
+	if err != nil {
+		return def, err
+	}
+	defer obj.Close()
+
+	return obj.ToInt(), nil
+}
+
+// LookupBool returns the boolean at the given path. If there is no boolean
+// at the path, def is returned along with an error wrapping either
+// ErrPathNotFound or ErrWrongType.
+func (o *Object) LookupBool(path string, def bool) (bool, error) {
+	obj, err := o.lookupType(path, ObjectTypeBoolean)
+	if err != nil {
+		return def, err
+	}
+	defer obj.Close()
+
+	return obj.ToBool(), nil
+}
+
+func (o *Object) lookupType(path string, t ObjectType) (*Object, error) {
+	obj := o.Lookup(path)
+	if obj == nil {
+		return nil, fmt.Errorf("%s: %w", path, ErrPathNotFound)
+	}
+
+	if obj.Type() != t {
+		actual := obj.Type()
+		obj.Close()
+		return nil, fmt.Errorf(
+			"%s: %w: expected %s, got %s", path, ErrWrongType, t, actual)
+	}
+
+	return obj, nil
+}
+
+// lookupPart finds a single path component within obj.
+func lookupPart(obj *C.ucl_object_t, part string) *C.ucl_object_t {
+	if ObjectType(C.ucl_object_type(obj)) == ObjectTypeObject {
+		// Search every object of an implicit array, in the same way that
+		// Decode merges them.
+		for elt := obj; elt != nil; elt = elt.next {
+			if ObjectType(C.ucl_object_type(elt)) != ObjectTypeObject {
+				continue
+			}
+
+			cpart := C.CString(part)
+			found := C.ucl_object_find_keyl(elt, cpart, C.size_t(len(part)))
+			C.free(unsafe.Pointer(cpart))
+			if found != nil {
+				return (*C.ucl_object_t)(found)
+			}
+		}
+	}
+
+	index, err := strconv.Atoi(part)
+	if err != nil || index < 0 {
+		return nil
+	}
+
+	if ObjectType(C.ucl_object_type(obj)) == ObjectTypeArray {
+		return (*C.ucl_object_t)(C.ucl_array_find_index(obj, C.uint(index)))
+	}
+
+	// Anything else is treated as an implicit array, where a single value
+	// is an array of one element.
+	elt := obj
+	for ; elt != nil && index > 0; index-- {
+		elt = elt.next
+	}
+	return elt
+}
+
+// splitPath splits a lookup path on unescaped dots.
+func splitPath(path string) []string {
+	var parts []string
+	var current strings.Builder
+
+	escaped := false
+	for _, c := range path {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+
+	return append(parts, current.String())
+}
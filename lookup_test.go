@@ -0,0 +1,155 @@
+package libucl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestObjectLookup(t *testing.T) {
+	obj := testParseString(t, `
+	server {
+		tls { cert = "/etc/cert.pem"; }
+	}
+	listeners = [
+		{ port = 80; },
+		{ port = 443; },
+	]
+	backend { host = a; }
+	backend { host = b; }
+	hosts { "example.com" { port = 8080; } }
+	`)
+	defer obj.Close()
+
+	cases := []struct {
+		Path     string
+		Expected interface{}
+	}{
+		{"server.tls.cert", "/etc/cert.pem"},
+		{"listeners.0.port", 80},
+		{"listeners.1.port", 443},
+		{"backend.0.host", "a"},
+		{"backend.1.host", "b"},
+		{`hosts.example\.com.port`, 8080},
+	}
+
+	for _, tc := range cases {
+		v := obj.Lookup(tc.Path)
+		if v == nil {
+			t.Fatalf("%s: should find", tc.Path)
+		}
+
+		var result interface{}
+		err := v.Decode(&result)
+		v.Close()
+		if err != nil {
+			t.Fatalf("%s: err: %s", tc.Path, err)
+		}
+
+		if !reflect.DeepEqual(result, tc.Expected) {
+			t.Fatalf("%s: bad: %#v", tc.Path, result)
+		}
+	}
+}
+
+func TestObjectLookup_missing(t *testing.T) {
+	obj := testParseString(t, "foo { bar = baz; }; list = [1, 2];")
+	defer obj.Close()
+
+	for _, path := range []string{"bar", "foo.baz", "foo.bar.baz", "list.2", "list.x"} {
+		if v := obj.Lookup(path); v != nil {
+			v.Close()
+			t.Fatalf("%s: should not find", path)
+		}
+	}
+}
+
+func TestObjectLookupString(t *testing.T) {
+	obj := testParseString(t, "foo { bar = baz; num = 42; }")
+	defer obj.Close()
+
+	v, err := obj.LookupString("foo.bar", "def")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v != "baz" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	v, err = obj.LookupString("foo.qux", "def")
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if v != "def" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	v, err = obj.LookupString("foo.num", "def")
+	if !errors.Is(err, ErrWrongType) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if v != "def" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
+
+func TestObjectLookupInt(t *testing.T) {
+	obj := testParseString(t, "foo { bar = baz; num = 42; }")
+	defer obj.Close()
+
+	v, err := obj.LookupInt("foo.num", 7)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if v != 42 {
+		t.Fatalf("bad: %d", v)
+	}
+
+	v, err = obj.LookupInt("foo.bar", 7)
+	if !errors.Is(err, ErrWrongType) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if v != 7 {
+		t.Fatalf("bad: %d", v)
+	}
+}
+
+func TestObjectLookupBool(t *testing.T) {
+	obj := testParseString(t, "foo { enabled = true; }")
+	defer obj.Close()
+
+	v, err := obj.LookupBool("foo.enabled", false)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !v {
+		t.Fatal("bad: false, expected: true")
+	}
+
+	v, err = obj.LookupBool("foo.disabled", true)
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if !v {
+		t.Fatal("bad: false, expected: true")
+	}
+}
+
+func TestSplitPath(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Expected []string
+	}{
+		{"foo", []string{"foo"}},
+		{"foo.bar.0", []string{"foo", "bar", "0"}},
+		{`foo\.bar.baz`, []string{"foo.bar", "baz"}},
+		{`foo\\.bar`, []string{`foo\`, "bar"}},
+	}
+
+	for _, tc := range cases {
+		actual := splitPath(tc.Path)
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Fatalf("%s: bad: %#v", tc.Path, actual)
+		}
+	}
+}
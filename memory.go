@@ -0,0 +1,85 @@
+package libucl
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// Non-zero if values should be closed by a finalizer
+var autoClose int32
+
+// SetAutoClose enables or disables automatically closing Objects, ObjectIters
+// and Parsers that are garbage collected without having been closed. It only
+// applies to values created after it is called.
+//
+// Closing values explicitly is still recommended: the garbage collector
+// doesn't know about the memory used by libucl, so it may run much later
+// than you'd expect. Parsers with an include handler or filesystem are never
+// collected: the .include macros call back into the parser, and macros are
+// kept track of globally until the parser is closed. Macros registered with
+// RegisterMacro are only referred to by a handle, so they don't keep the
+// parser alive and are released when it is collected.
+func SetAutoClose(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&autoClose, v)
+}
+
+// Leak is an Object, ObjectIter or Parser that was created but never closed.
+type Leak struct {
+	// Kind is the type of the leaked value.
+	Kind string
+	// Stack is the stack trace of where the value was created.
+	Stack string
+}
+
+func (l Leak) String() string {
+	return fmt.Sprintf("%s allocated at:\n%s", l.Kind, l.Stack)
+}
+
+// newObject wraps a C object that we hold a reference to.
+func newObject(obj *C.ucl_object_t) *Object {
+	o := &Object{object: obj}
+	o.leakID = trackAlloc("Object")
+
+	if atomic.LoadInt32(&autoClose) != 0 {
+		o.autoClose = true
+		runtime.SetFinalizer(o, (*Object).Close)
+	}
+	return o
+}
+
+// newObjectIter creates an iterator over obj, which must already have been
+// referenced for it.
+func newObjectIter(obj *C.ucl_object_t, expand bool) *ObjectIter {
+	o := &ObjectIter{
+		object:    obj,
+		iter:      C.ucl_object_iterate_new(obj),
+		remaining: -1,
+	}
+
+	// Expanding walks the keys or items of obj itself. The safe iterator
+	// would go on to those of every other value of a repeated key, so it
+	// stops after the ones of obj, and values that aren't containers are
+	// walked along the chain of the repeated key as they are unexpanded.
+	if expand {
+		switch C.ucl_object_type(obj) {
+		case C.UCL_OBJECT, C.UCL_ARRAY:
+			o.expand = true
+			o.remaining = int(obj.len)
+		}
+	}
+	o.leakID = trackAlloc("ObjectIter")
+
+	if atomic.LoadInt32(&autoClose) != 0 {
+		o.autoClose = true
+		runtime.SetFinalizer(o, (*ObjectIter).Close)
+	}
+	return o
+}
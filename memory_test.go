@@ -0,0 +1,47 @@
+package libucl
+
+import (
+	"testing"
+)
+
+func TestSetAutoClose(t *testing.T) {
+	SetAutoClose(true)
+	defer SetAutoClose(false)
+
+	p := NewParser(0)
+	if !p.autoClose {
+		t.Fatal("parser should be closed automatically")
+	}
+	if err := p.AddString("foo = bar;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if !obj.autoClose {
+		t.Fatal("object should be closed automatically")
+	}
+
+	iter := obj.Iterate(true)
+	if !iter.autoClose {
+		t.Fatal("iterator should be closed automatically")
+	}
+
+	// Closing explicitly must still be safe
+	iter.Close()
+	obj.Close()
+	p.Close()
+	p.Close()
+
+	if obj.autoClose || iter.autoClose || p.autoClose {
+		t.Fatal("closed values should not have finalizers")
+	}
+}
+
+func TestSetAutoClose_disabled(t *testing.T) {
+	obj := NewObject("foo")
+	defer obj.Close()
+
+	if obj.autoClose {
+		t.Fatal("object should not be closed automatically")
+	}
+}
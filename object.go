@@ -1,6 +1,13 @@
 package libucl
 
-import "unsafe"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+	"unsafe"
+)
 
 // #include "go-libucl.h"
 import "C"
@@ -8,6 +15,12 @@ import "C"
 // Object represents a single object within a configuration.
 type Object struct {
 	object *C.ucl_object_t
+
+	// Set if the object will be closed by a finalizer
+	autoClose bool
+
+	// Identifies the object to the leak checker
+	leakID uint64
 }
 
 // ObjectIter is an interator for objects.
@@ -15,6 +28,15 @@ type ObjectIter struct {
 	expand bool
 	object *C.ucl_object_t
 	iter   C.ucl_object_iter_t
+
+	// How many more values to return, or -1 for no limit
+	remaining int
+
+	// Set if the iterator will be closed by a finalizer
+	autoClose bool
+
+	// Identifies the iterator to the leak checker
+	leakID uint64
 }
 
 // ObjectType is an enum of the type that an Object represents.
@@ -42,6 +64,26 @@ const (
 	ObjectTypeNull
 )
 
+var objectTypeNames = map[ObjectType]string{
+	ObjectTypeObject:   "object",
+	ObjectTypeArray:    "array",
+	ObjectTypeInt:      "int",
+	ObjectTypeFloat:    "float",
+	ObjectTypeString:   "string",
+	ObjectTypeBoolean:  "boolean",
+	ObjectTypeTime:     "time",
+	ObjectTypeUserData: "userdata",
+	ObjectTypeNull:     "null",
+}
+
+// String returns the name of the object type as used by libucl.
+func (t ObjectType) String() string {
+	if name, ok := objectTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ObjectType(%d)", int(t))
+}
+
 // Emitter is a type of built-in emitter that can be used to convert
 // an object to another config format. All Emitters except EmitConfig
 // are considered lossy, and information such as implicit arrays can be lost.
@@ -56,28 +98,40 @@ const (
 	EmitConfig
 	// EmitYAML is yaml inlined notation
 	EmitYAML
+	// EmitMsgpack is binary MessagePack
+	EmitMsgpack
 )
 
 // Close frees the memory associated with the object. This must be called when
-// you're done using it.
+// you're done using it, unless SetAutoClose was enabled when it was created.
 func (o *Object) Close() error {
+	if o.autoClose {
+		o.autoClose = false
+		runtime.SetFinalizer(o, nil)
+	}
+	trackRelease(o.leakID)
+
 	C.ucl_object_unref(o.object)
 	return nil
 }
 
 // Emit converts this object to another format and returns it.
 func (o *Object) Emit(t Emitter) (string, error) {
-	result := C.ucl_object_emit(o.object, uint32(t))
+	defer runtime.KeepAlive(o)
+	var length C.size_t
+	result := C.ucl_object_emit_len(o.object, uint32(t), &length)
 	if result == nil {
 		return "", nil
 	}
+	defer C.free(unsafe.Pointer(result))
 
-	return C.GoString(C._go_uchar_to_char(result)), nil
+	return C.GoStringN(C._go_uchar_to_char(result), C.int(length)), nil
 }
 
 // Delete removes the given key from the object. The key will automatically
 // be dereferenced once when this is called.
 func (o *Object) Delete(key string) {
+	defer runtime.KeepAlive(o)
 	ckey := C.CString(key)
 	defer C.free(unsafe.Pointer(ckey))
 
@@ -86,6 +140,7 @@ func (o *Object) Delete(key string) {
 
 // Get returns the element with matching key.
 func (o *Object) Get(key string) *Object {
+	defer runtime.KeepAlive(o)
 	ckey := C.CString(key)
 	defer C.free(unsafe.Pointer(ckey))
 
@@ -94,7 +149,7 @@ func (o *Object) Get(key string) *Object {
 		return nil
 	}
 
-	result := &Object{object: obj}
+	result := newObject(obj)
 	result.Ref()
 	return result
 }
@@ -105,19 +160,17 @@ func (o *Object) Get(key string) *Object {
 //
 // The iterator does not need to be fully consumed.
 func (o *Object) Iterate(expand bool) *ObjectIter {
+	defer runtime.KeepAlive(o)
 	// Increase the ref count
 	C.ucl_object_ref(o.object)
 
-	return &ObjectIter{
-		expand: expand,
-		object: o.object,
-		iter:   nil,
-	}
+	return newObjectIter(o.object, expand)
 }
 
 // Key returns the key of this value/object as a string, or the empty
 // string if the object doesn't have a key.
 func (o *Object) Key() string {
+	defer runtime.KeepAlive(o)
 	return C.GoString(C.ucl_object_key(o.object))
 }
 
@@ -127,6 +180,7 @@ func (o *Object) Key() string {
 // For objects, this is the number of key/value pairs.
 // For arrays, this is the number of elements.
 func (o *Object) Len() uint {
+	defer runtime.KeepAlive(o)
 	// This is weird. If the object is an object and it has a "next",
 	// then it is actually an array of objects, and to get the count
 	// we actually need to iterate and count.
@@ -146,63 +200,306 @@ func (o *Object) Len() uint {
 	return uint(o.object.len)
 }
 
+// Priority returns the priority of this object, which is the priority of the
+// chunk or file it was parsed from.
+func (o *Object) Priority() uint {
+	defer runtime.KeepAlive(o)
+	return uint(C.ucl_object_get_priority(o.object))
+}
+
+// SetPriority sets the priority of this object, from 0 to 15. It panics if
+// the priority is out of range.
+func (o *Object) SetPriority(priority uint) {
+	defer runtime.KeepAlive(o)
+	if err := checkPriority(priority); err != nil {
+		panic(err)
+	}
+	C.ucl_object_set_priority(o.object, C.uint(priority))
+}
+
 // Ref increments the ref count associated with this. You have to call
 // close an additional time to free the memory.
 func (o *Object) Ref() error {
+	defer runtime.KeepAlive(o)
 	C.ucl_object_ref(o.object)
 	return nil
 }
 
 // Type returns the type that this object represents.
 func (o *Object) Type() ObjectType {
+	defer runtime.KeepAlive(o)
 	return ObjectType(C.ucl_object_type(o.object))
 }
 
+//------------------------------------------------------------------------
+// Mutation Functions
+//
+// The functions that add an object to another one take their own reference
+// to it, so the added object must still be closed by the caller. An object
+// can only be part of a single object or array at any time.
+//------------------------------------------------------------------------
+
+// Insert adds value to this object under the given key. If the key already
+// exists, the values are combined into an implicit array.
+func (o *Object) Insert(key string, value *Object) error {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(value)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	C.ucl_object_ref(value.object)
+	if !C.ucl_object_insert_key(o.object, value.object, ckey, C.size_t(len(key)), true) {
+		C.ucl_object_unref(value.object)
+		return errors.New("cannot insert key into non-object")
+	}
+	return nil
+}
+
+// Set adds value to this object under the given key, replacing any existing
+// value. The existing value is dereferenced once.
+func (o *Object) Set(key string, value *Object) error {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(value)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	C.ucl_object_ref(value.object)
+	if !C.ucl_object_replace_key(o.object, value.object, ckey, C.size_t(len(key)), true) {
+		C.ucl_object_unref(value.object)
+		return errors.New("cannot set key in non-object")
+	}
+	return nil
+}
+
+// PopKey removes the given key from the object and returns its value, or nil
+// if the key doesn't exist. The returned object must be closed.
+func (o *Object) PopKey(key string) *Object {
+	defer runtime.KeepAlive(o)
+	ckey := C.CString(key)
+	defer C.free(unsafe.Pointer(ckey))
+
+	obj := C.ucl_object_pop_keyl(o.object, ckey, C.size_t(len(key)))
+	if obj == nil {
+		return nil
+	}
+
+	return newObject(obj)
+}
+
+// Append adds value to the end of this array.
+func (o *Object) Append(value *Object) error {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(value)
+	if o.Type() != ObjectTypeArray {
+		return errors.New("cannot append to non-array")
+	}
+
+	C.ucl_object_ref(value.object)
+	C.ucl_array_append(o.object, value.object)
+	return nil
+}
+
+// Prepend adds value to the start of this array.
+func (o *Object) Prepend(value *Object) error {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(value)
+	if o.Type() != ObjectTypeArray {
+		return errors.New("cannot prepend to non-array")
+	}
+
+	C.ucl_object_ref(value.object)
+	C.ucl_array_prepend(o.object, value.object)
+	return nil
+}
+
+// Index returns the element of this array at the given index, or nil if the
+// index is out of range.
+func (o *Object) Index(index int) *Object {
+	defer runtime.KeepAlive(o)
+	if index < 0 || o.Type() != ObjectTypeArray {
+		return nil
+	}
+
+	obj := C.ucl_array_find_index(o.object, C.uint(index))
+	if obj == nil {
+		return nil
+	}
+
+	result := newObject(obj)
+	result.Ref()
+	return result
+}
+
+// Replace replaces the element of this array at the given index with value,
+// and returns the previous element. The returned object must be closed.
+func (o *Object) Replace(index int, value *Object) (*Object, error) {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(value)
+	if o.Type() != ObjectTypeArray {
+		return nil, errors.New("cannot replace in non-array")
+	}
+	if index < 0 || index >= int(o.Len()) {
+		return nil, errors.New("array index out of range")
+	}
+
+	C.ucl_object_ref(value.object)
+	obj := C.ucl_array_replace_index(o.object, value.object, C.uint(index))
+	if obj == nil {
+		C.ucl_object_unref(value.object)
+		return nil, errors.New("array index out of range")
+	}
+
+	return newObject(obj), nil
+}
+
+// DeleteIndex removes the element at the given index from this array. The
+// element will automatically be dereferenced once when this is called.
+func (o *Object) DeleteIndex(index int) {
+	defer runtime.KeepAlive(o)
+	if index < 0 || o.Type() != ObjectTypeArray {
+		return
+	}
+
+	elt := C.ucl_array_find_index(o.object, C.uint(index))
+	if elt == nil {
+		return
+	}
+
+	obj := C.ucl_array_delete(o.object, (*C.ucl_object_t)(elt))
+	if obj != nil {
+		C.ucl_object_unref(obj)
+	}
+}
+
+// Pop removes the last element of this array and returns it, or nil if the
+// array is empty. The returned object must be closed.
+func (o *Object) Pop() *Object {
+	defer runtime.KeepAlive(o)
+	if o.Type() != ObjectTypeArray {
+		return nil
+	}
+
+	obj := C.ucl_array_pop_last(o.object)
+	if obj == nil {
+		return nil
+	}
+
+	return newObject(obj)
+}
+
+// PopFirst removes the first element of this array and returns it, or nil if
+// the array is empty. The returned object must be closed.
+func (o *Object) PopFirst() *Object {
+	defer runtime.KeepAlive(o)
+	if o.Type() != ObjectTypeArray {
+		return nil
+	}
+
+	obj := C.ucl_array_pop_first(o.object)
+	if obj == nil {
+		return nil
+	}
+
+	return newObject(obj)
+}
+
+// Copy returns a deep copy of this object. The copy must be closed.
+func (o *Object) Copy() *Object {
+	defer runtime.KeepAlive(o)
+	return newObject(C.ucl_object_copy(o.object))
+}
+
 //------------------------------------------------------------------------
 // Conversion Functions
 //------------------------------------------------------------------------
 
 // ToBool converts a UCL Object to a boolean value
 func (o *Object) ToBool() bool {
+	defer runtime.KeepAlive(o)
 	return bool(C.ucl_object_toboolean(o.object))
 }
 
 // ToInt converts a UCL Object to a signed integer value
 func (o *Object) ToInt() int64 {
+	defer runtime.KeepAlive(o)
 	return int64(C.ucl_object_toint(o.object))
 }
 
 // ToUint converts a UCL Object to an unsigned integer value
 func (o *Object) ToUint() uint64 {
+	defer runtime.KeepAlive(o)
 	return uint64(C.ucl_object_toint(o.object))
 }
 
 // ToFloat converts a UCL Object to an floating point value
 func (o *Object) ToFloat() float64 {
+	defer runtime.KeepAlive(o)
 	return float64(C.ucl_object_todouble(o.object))
 }
 
+// ToDuration converts a UCL Object holding a number of seconds, such as a
+// time value like "10s" or "5min", to a duration. Values out of range are
+// clamped to the shortest or longest duration.
+func (o *Object) ToDuration() time.Duration {
+	d, _ := secondsToDuration(o.ToFloat())
+	return d
+}
+
+// secondsToDuration converts a number of seconds to a duration, and returns
+// whether it is in range. If it isn't, the duration is clamped.
+func secondsToDuration(s float64) (time.Duration, bool) {
+	ns := s * float64(time.Second)
+	switch {
+	case math.IsNaN(ns):
+		return 0, false
+	case ns >= math.MaxInt64:
+		return math.MaxInt64, false
+	case ns < math.MinInt64:
+		return math.MinInt64, false
+	}
+	return time.Duration(ns), true
+}
+
 // ToString converts a UCL Object to a string
 func (o *Object) ToString() string {
+	defer runtime.KeepAlive(o)
 	return C.GoString(C.ucl_object_tostring(o.object))
 }
 
 // Close frees the object iterator
 func (o *ObjectIter) Close() {
+	if o.autoClose {
+		o.autoClose = false
+		runtime.SetFinalizer(o, nil)
+	}
+	trackRelease(o.leakID)
+
+	// libucl only frees its own state when iteration runs to the end, so
+	// the iterator is freed here in case the loop stopped early.
+	C.ucl_object_iterate_free(o.iter)
+	o.iter = nil
 	C.ucl_object_unref(o.object)
 }
 
 // Next returns the next iterative UCL Object
 func (o *ObjectIter) Next() *Object {
-	obj := C.ucl_object_iterate_with_error(o.object, &o.iter, C._Bool(o.expand), nil)
+	defer runtime.KeepAlive(o)
+	if o.remaining == 0 {
+		return nil
+	}
+
+	obj := C._go_object_iterate_safe(o.iter, C._Bool(o.expand))
 	if obj == nil {
 		return nil
 	}
+	if o.remaining > 0 {
+		o.remaining--
+	}
 
 	// Increase the ref count so we have to free it
 	C.ucl_object_ref(obj)
 
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // StringFlag are flags used in the conversion of strings into UCL objects
@@ -238,7 +535,7 @@ func NewObject(data string) *Object {
 	cData := C.CString(data)
 	defer C.free(unsafe.Pointer(cData))
 	obj := C.ucl_object_fromlstring(cData, C.size_t(len(data)))
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // NewFormattedObject creates a new UCL Object from a string, according to the instructions
@@ -247,23 +544,41 @@ func NewFormattedObject(data string, flags StringFlag) *Object {
 	cData := C.CString(data)
 	defer C.free(unsafe.Pointer(cData))
 	obj := C.ucl_object_fromstring_common(cData, C.size_t(len(data)), uint32(flags))
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // NewIntegerObject creates a new UCL Object from a 64-bit integer
 func NewIntegerObject(data int64) *Object {
 	obj := C.ucl_object_fromint(C.int64_t(data))
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // NewDoubleObject creates a new UCL Object from a 64-bit floating-point number
 func NewDoubleObject(data float64) *Object {
 	obj := C.ucl_object_fromdouble(C.double(data))
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // NewBoolObject creates a new UCL Object from a boolean
 func NewBoolObject(data bool) *Object {
 	obj := C.ucl_object_frombool(C.bool(data))
-	return &Object{object: obj}
+	return newObject(obj)
+}
+
+// NewObjectMap creates a new, empty UCL Object that holds key/value pairs
+func NewObjectMap() *Object {
+	obj := C.ucl_object_typed_new(C.UCL_OBJECT)
+	return newObject(obj)
+}
+
+// NewArray creates a new, empty UCL array
+func NewArray() *Object {
+	obj := C.ucl_object_typed_new(C.UCL_ARRAY)
+	return newObject(obj)
+}
+
+// NewNullObject creates a new UCL null Object
+func NewNullObject() *Object {
+	obj := C.ucl_object_typed_new(C.UCL_NULL)
+	return newObject(obj)
 }
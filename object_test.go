@@ -3,6 +3,7 @@ package libucl
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestObjectEmit(t *testing.T) {
@@ -155,6 +156,48 @@ func TestObjectIterate_array(t *testing.T) {
 	}
 }
 
+func TestObjectIterate_break(t *testing.T) {
+	obj := testParseString(t, "a = 1; b = 2; c = 3;")
+	defer obj.Close()
+
+	// Closing an iterator that didn't run to the end frees it
+	for i := 0; i < 100; i++ {
+		iter := obj.Iterate(true)
+		elem := iter.Next()
+		if elem == nil {
+			t.Fatal("should have element")
+		}
+		elem.Close()
+		iter.Close()
+	}
+}
+
+func TestObjectIterate_repeatedKey(t *testing.T) {
+	obj := testParseString(t, "foo { a = 1; b = 2; } foo { c = 3; }")
+	defer obj.Close()
+
+	foo := obj.Get("foo")
+	if foo == nil {
+		t.Fatal("should have object")
+	}
+	defer foo.Close()
+
+	// Expanding only walks the keys of the first object for the key
+	iter := foo.Iterate(true)
+	defer iter.Close()
+
+	var result []string
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		result = append(result, elem.Key())
+		elem.Close()
+	}
+
+	expected := []string{"a", "b"}
+	if !reflect.DeepEqual(expected, result) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
 func TestObjectToBool(t *testing.T) {
 	obj := testParseString(t, "foo = true; bar = false;")
 	defer obj.Close()
@@ -235,6 +278,26 @@ func TestObjectToFloat_negativeOneThird(t *testing.T) {
 	}
 }
 
+func TestObjectToDuration(t *testing.T) {
+	obj := testParseString(t, "foo = 1.5min; bar = 2; ")
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	defer v.Close()
+	if v.Type() != ObjectTypeTime {
+		t.Fatalf("bad: %s", v.Type())
+	}
+	if v.ToDuration() != 90*time.Second {
+		t.Fatalf("bad: %s", v.ToDuration())
+	}
+
+	v2 := obj.Get("bar")
+	defer v2.Close()
+	if v2.ToDuration() != 2*time.Second {
+		t.Fatalf("bad: %s", v2.ToDuration())
+	}
+}
+
 func TestIntToObject(t *testing.T) {
 	var testValue int64 = 42
 	obj := NewIntegerObject(testValue)
@@ -328,3 +391,207 @@ func TestStringTrimToObject(t *testing.T) {
 		t.Fatalf("bad: \"%s\", expected: \"%s\"", obj.ToString(), expectedResult)
 	}
 }
+
+func TestObjectSet(t *testing.T) {
+	obj := NewObjectMap()
+	defer obj.Close()
+
+	foo := NewObject("bar")
+	defer foo.Close()
+	if err := obj.Set("foo", foo); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	baz := NewIntegerObject(42)
+	defer baz.Close()
+	if err := obj.Set("foo", baz); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := obj.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"foo":42}`
+	if result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectSet_nonObject(t *testing.T) {
+	obj := NewArray()
+	defer obj.Close()
+
+	v := NewObject("bar")
+	defer v.Close()
+	if err := obj.Set("foo", v); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestObjectInsert(t *testing.T) {
+	obj := NewObjectMap()
+	defer obj.Close()
+
+	for _, s := range []string{"foo", "bar"} {
+		v := NewObject(s)
+		if err := obj.Insert("foo", v); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		v.Close()
+	}
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+
+	var result []string
+	if err := v.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := []string{"foo", "bar"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+}
+
+func TestObjectPopKey(t *testing.T) {
+	obj := testParseString(t, "foo = bar; baz = boo;")
+	defer obj.Close()
+
+	v := obj.PopKey("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.ToString() != "bar" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+	if obj.Len() != 1 {
+		t.Fatalf("bad: %d", obj.Len())
+	}
+
+	if v := obj.PopKey("foo"); v != nil {
+		v.Close()
+		t.Fatal("should not find")
+	}
+}
+
+func TestObjectArray(t *testing.T) {
+	arr := NewArray()
+	defer arr.Close()
+
+	for i := int64(1); i <= 3; i++ {
+		v := NewIntegerObject(i)
+		if err := arr.Append(v); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		v.Close()
+	}
+
+	v := NewIntegerObject(0)
+	if err := arr.Prepend(v); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	v.Close()
+
+	v = NewIntegerObject(42)
+	old, err := arr.Replace(2, v)
+	v.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if old.ToInt() != 2 {
+		t.Fatalf("bad: %d", old.ToInt())
+	}
+	old.Close()
+
+	arr.DeleteIndex(1)
+
+	result, err := arr.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if result != "[0,42,3]" {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if v := arr.Index(1); v == nil || v.ToInt() != 42 {
+		t.Fatalf("bad: %#v", v)
+	} else {
+		v.Close()
+	}
+	if v := arr.Index(3); v != nil {
+		v.Close()
+		t.Fatal("should not find")
+	}
+
+	last := arr.Pop()
+	if last == nil || last.ToInt() != 3 {
+		t.Fatalf("bad: %#v", last)
+	}
+	last.Close()
+
+	first := arr.PopFirst()
+	if first == nil || first.ToInt() != 0 {
+		t.Fatalf("bad: %#v", first)
+	}
+	first.Close()
+
+	if arr.Len() != 1 {
+		t.Fatalf("bad: %d", arr.Len())
+	}
+}
+
+func TestObjectAppend_nonArray(t *testing.T) {
+	obj := NewObjectMap()
+	defer obj.Close()
+
+	v := NewObject("bar")
+	defer v.Close()
+	if err := obj.Append(v); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestObjectCopy(t *testing.T) {
+	obj := testParseString(t, "foo = bar;")
+	defer obj.Close()
+
+	cp := obj.Copy()
+	defer cp.Close()
+	cp.Delete("foo")
+
+	if obj.Len() != 1 {
+		t.Fatalf("bad: %d", obj.Len())
+	}
+	if cp.Len() != 0 {
+		t.Fatalf("bad: %d", cp.Len())
+	}
+}
+
+func TestObjectSetPriority(t *testing.T) {
+	obj := NewObject("foo")
+	defer obj.Close()
+
+	obj.SetPriority(5)
+	if obj.Priority() != 5 {
+		t.Fatalf("bad: %d", obj.Priority())
+	}
+}
+
+func TestObjectSetPriority_outOfRange(t *testing.T) {
+	obj := NewObject("foo")
+	defer obj.Close()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("should panic")
+		}
+	}()
+	obj.SetPriority(16)
+}
@@ -1,23 +1,26 @@
 package libucl
 
 import (
-	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
 // #include "go-libucl.h"
 import "C"
 
-// MacroFunc is the callback type for macros.
-// return true os string is valid
-// a macro call looks like:
-//
-//   .macro(UCL-OBJECT) "body-text"
-//   .macro(key=value) "body-text"
-//   .macro(params={key1=value1;key2=value2}) "body"
+// MacroFunc is the callback type for macros. It returns whether the body
+// is valid, but the parse carries on either way, so a macro that needs to
+// report a failure has to do so itself. A macro call looks like:
 //
+//	.macro(UCL-OBJECT) "body-text"
+//	.macro(key=value) "body-text"
+//	.macro(params={key1=value1;key2=value2}) "body"
 type MacroFunc func(args Object, body string) bool
 
 // ParserFlag are flags that can be used to initialize a parser.
@@ -35,15 +38,83 @@ const (
 	ParserNoImplicitArrays ParserFlag = C.UCL_PARSER_NO_IMPLICIT_ARRAYS
 )
 
+// ParseType is the format of the data given to a parser.
+type ParseType int
+
+const (
+	// ParseUCL is UCL, which includes JSON
+	ParseUCL ParseType = C.UCL_PARSE_UCL
+	// ParseMsgpack is binary MessagePack
+	ParseMsgpack ParseType = C.UCL_PARSE_MSGPACK
+	// ParseCSexp is canonical S-expressions
+	ParseCSexp ParseType = C.UCL_PARSE_CSEXP
+	// ParseAuto tries to detect the format of the data
+	ParseAuto ParseType = C.UCL_PARSE_AUTO
+)
+
+// DuplicateStrategy is how a parser handles a key that already exists when it
+// is added again, possibly from another chunk or file.
+type DuplicateStrategy int
+
+const (
+	// DuplicateAppend combines the values into an implicit array, unless the
+	// new value has a lower priority, in which case it is ignored, or a
+	// higher priority, in which case it replaces the existing value.
+	DuplicateAppend DuplicateStrategy = C.UCL_DUPLICATE_APPEND
+	// DuplicateMerge merges objects and arrays, and keeps the existing value
+	// for other types.
+	DuplicateMerge DuplicateStrategy = C.UCL_DUPLICATE_MERGE
+	// DuplicateRewrite always replaces the existing value.
+	DuplicateRewrite DuplicateStrategy = C.UCL_DUPLICATE_REWRITE
+	// DuplicateError fails the parse on a duplicate key.
+	DuplicateError DuplicateStrategy = C.UCL_DUPLICATE_ERROR
+)
+
+// maxPriority is the highest priority libucl can store in an object.
+const maxPriority = 15
+
 // Keeps track of all the macros internally
-var macros map[int]MacroFunc
+var macros map[int]macro
 var macrosIdx int
 var macrosLock sync.Mutex
 
+// macro is a registered macro. The parse only fails when a strict macro
+// returns false; those are the package's own macros.
+type macro struct {
+	f      MacroFunc
+	strict bool
+}
+
 // Parser is responsible for parsing libucl data.
 type Parser struct {
+	flags  ParserFlag
 	macros []int
 	parser *C.struct_ucl_parser
+
+	// Resolves .include directives, if set, and the error that made one
+	// of them fail.
+	include    IncludeHandler
+	includeErr *ParseError
+
+	// The file being included, if any.
+	includeFile *IncludeFile
+
+	// The state of the variable handler, if one was set, and the ID it is
+	// registered under.
+	variables    *variableState
+	variablesIdx int
+
+	// Set if the parser will be closed by a finalizer
+	autoClose bool
+
+	// Identifies the parser to the leak checker
+	leakID uint64
+
+	// libucl keeps a pointer to every chunk of data it has parsed, so the
+	// data is either copied to C memory, which is freed when the parser is
+	// closed, or pinned with ParserZeroCopy.
+	chunks []unsafe.Pointer
+	pinner runtime.Pinner
 }
 
 // ParseString parses a string and returns the top-level object.
@@ -59,42 +130,167 @@ func ParseString(data string) (*Object, error) {
 
 // NewParser returns a parser
 func NewParser(flags ParserFlag) *Parser {
-	return &Parser{
+	p := &Parser{
+		flags:  flags,
 		parser: C.ucl_parser_new(C.int(flags)),
 	}
+	p.leakID = trackAlloc("Parser")
+
+	if atomic.LoadInt32(&autoClose) != 0 {
+		p.autoClose = true
+		runtime.SetFinalizer(p, (*Parser).Close)
+	}
+	return p
 }
 
 // AddString adds a string data to parse.
 func (p *Parser) AddString(data string) error {
-	cs := C.CString(data)
-	defer C.free(unsafe.Pointer(cs))
+	return p.AddBytes([]byte(data))
+}
 
-	result := C.ucl_parser_add_string(p.parser, cs, C.size_t(len(data)))
-	if !result {
-		errstr := C.ucl_parser_get_error(p.parser)
-		return errors.New(C.GoString(errstr))
+// AddStringWithPriority adds a string data to parse, with the given priority
+// and duplicate strategy. Priorities range from 0 to 15; when the same key
+// is found in several chunks, values with a higher priority win.
+func (p *Parser) AddStringWithPriority(data string, priority uint, strategy DuplicateStrategy) error {
+	if err := checkPriority(priority); err != nil {
+		return err
+	}
+	return p.addChunk([]byte(data), priority, strategy, ParseUCL)
+}
+
+// AddBytes adds UCL data to parse. The data may contain any bytes, including
+// NULs.
+//
+// The data is normally copied. If the parser was created with
+// ParserZeroCopy, the data is used as it is and pinned until the parser is
+// closed, and the parsed objects point into it. The data must not be
+// modified after it is added, and the objects must not be used after the
+// parser is closed.
+func (p *Parser) AddBytes(data []byte) error {
+	return p.AddChunk(data, ParseUCL)
+}
+
+// AddReader reads all of r and adds it as UCL data to parse.
+func (p *Parser) AddReader(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	return p.AddBytes(data)
+}
+
+// AddChunk adds data in the given format to parse. See AddBytes for how
+// the data is handled with ParserZeroCopy.
+func (p *Parser) AddChunk(data []byte, t ParseType) error {
+	defer runtime.KeepAlive(p)
+	priority := uint(C.ucl_parser_get_default_priority(p.parser))
+	return p.addChunk(data, priority, DuplicateAppend, t)
+}
+
+func (p *Parser) addChunk(data []byte, priority uint, strategy DuplicateStrategy, t ParseType) error {
+	defer runtime.KeepAlive(p)
+	if !p.parseChunk(data, priority, strategy, t) {
+		// Only text formats can have a meaningful snippet
+		if t != ParseUCL {
+			data = nil
+		}
+		return p.parseError("", data)
+	}
+	return p.variableErr("", data)
+}
+
+// parseChunk passes data to libucl and reports whether it was parsed.
+func (p *Parser) parseChunk(data []byte, priority uint, strategy DuplicateStrategy, t ParseType) bool {
+	result := C.ucl_parser_add_chunk_full(
+		p.parser,
+		p.chunk(data),
+		C.size_t(len(data)),
+		C.uint(priority),
+		uint32(strategy),
+		uint32(t))
+	return bool(result)
+}
+
+// chunk returns a pointer to data that can be given to libucl, which keeps
+// it for as long as the parser. With ParserZeroCopy the Go memory is pinned
+// so that libucl can use it directly, otherwise it is copied to C memory.
+func (p *Parser) chunk(data []byte) *C.uchar {
+	if p.flags&ParserZeroCopy != 0 && len(data) > 0 {
+		p.pinner.Pin(&data[0])
+		return (*C.uchar)(unsafe.Pointer(&data[0]))
+	}
+
+	// libucl rejects NULL, even for empty data
+	cs := C.malloc(C.size_t(len(data) + 1))
+	copy(unsafe.Slice((*byte)(cs), len(data)), data)
+	p.chunks = append(p.chunks, cs)
+	return (*C.uchar)(cs)
+}
+
+// checkPriority returns an error if the priority is too high for libucl to
+// store, which would otherwise mangle it silently.
+func checkPriority(priority uint) error {
+	if priority > maxPriority {
+		return fmt.Errorf("priority %d is out of range 0-%d", priority, maxPriority)
 	}
 	return nil
 }
 
 // AddFile adds a file to parse.
 func (p *Parser) AddFile(path string) error {
+	defer runtime.KeepAlive(p)
 	cs := C.CString(path)
 	defer C.free(unsafe.Pointer(cs))
 
 	result := C.ucl_parser_add_file(p.parser, cs)
 	if !result {
-		errstr := C.ucl_parser_get_error(p.parser)
-		return errors.New(C.GoString(errstr))
+		return p.parseError(path, nil)
 	}
-	return nil
+	return p.variableErr(path, nil)
+}
+
+// AddFileWithPriority adds a file to parse, with the given priority and
+// duplicate strategy. See AddStringWithPriority for how priorities work.
+func (p *Parser) AddFileWithPriority(path string, priority uint, strategy DuplicateStrategy) error {
+	defer runtime.KeepAlive(p)
+	if err := checkPriority(priority); err != nil {
+		return err
+	}
+
+	cs := C.CString(path)
+	defer C.free(unsafe.Pointer(cs))
+
+	result := C.ucl_parser_add_file_full(
+		p.parser, cs, C.uint(priority), uint32(strategy), C.UCL_PARSE_UCL)
+	if !result {
+		return p.parseError(path, nil)
+	}
+	return p.variableErr(path, nil)
 }
 
 // Close frees the parser. Once it is freed it can no longer be used. You
 // should always free the parser once you're done with it to clean up
 // any unused memory.
 func (p *Parser) Close() {
+	if p.parser == nil {
+		return
+	}
+	if p.autoClose {
+		p.autoClose = false
+		runtime.SetFinalizer(p, nil)
+	}
+	trackRelease(p.leakID)
+
 	C.ucl_parser_free(p.parser)
+	p.parser = nil
+	p.closeVariables()
+
+	for _, chunk := range p.chunks {
+		C.free(chunk)
+	}
+	p.chunks = nil
+	p.pinner.Unpin()
 
 	if len(p.macros) > 0 {
 		macrosLock.Lock()
@@ -107,26 +303,36 @@ func (p *Parser) Close() {
 
 // Object retrieves the root-level object for a configuration.
 func (p *Parser) Object() *Object {
+	defer runtime.KeepAlive(p)
 	obj := C.ucl_parser_get_object(p.parser)
 	if obj == nil {
 		return nil
 	}
 
-	return &Object{object: obj}
+	return newObject(obj)
 }
 
 // RegisterMacro registers a macro that is called from the configuration.
+// The parse carries on whatever the macro returns.
 func (p *Parser) RegisterMacro(name string, f MacroFunc) {
+	p.registerMacro(name, macro{f: f})
+}
+
+func (p *Parser) registerMacro(name string, m macro) {
+	defer runtime.KeepAlive(p)
 	// Register it globally
 	macrosLock.Lock()
 	if macros == nil {
-		macros = make(map[int]MacroFunc)
+		macros = make(map[int]macro)
 	}
-	for macros[macrosIdx] != nil {
+	for {
+		if _, ok := macros[macrosIdx]; !ok {
+			break
+		}
 		macrosIdx++
 	}
 	idx := macrosIdx
-	macros[idx] = f
+	macros[idx] = m
 	macrosIdx++
 	macrosLock.Unlock()
 
@@ -146,7 +352,7 @@ func (p *Parser) RegisterMacro(name string, f MacroFunc) {
 //export go_macro_call
 func go_macro_call(id C.int, arguments *C.ucl_object_t, data *C.char, n C.int) C.bool {
 	macrosLock.Lock()
-	f := macros[int(id)]
+	m, ok := macros[int(id)]
 	macrosLock.Unlock()
 
 	args := Object{
@@ -154,13 +360,13 @@ func go_macro_call(id C.int, arguments *C.ucl_object_t, data *C.char, n C.int) C
 	}
 
 	// Macro not found, return error
-	if f == nil {
+	if !ok {
 		return false
 	}
 
 	// Macro found, call it!
-	f(args, C.GoStringN(data, n))
-	return true
+	result := m.f(args, C.GoStringN(data, n))
+	return C.bool(result || !m.strict)
 }
 
 // SetFileVariables sets the standard file variables ($FILENAME and $CURDIR) based
@@ -171,12 +377,12 @@ func go_macro_call(id C.int, arguments *C.ucl_object_t, data *C.char, n C.int) C
 // ../file.conf, with exand = false, $FILENAME = ../file.conf and $CURDIR = ..,
 // while with expand = true, $FILENAME = /etc/file.conf and $CURDIR = /etc
 func (p *Parser) SetFileVariables(filepath string, expand bool) error {
+	defer runtime.KeepAlive(p)
 	cpath := C.CString(filepath)
 	defer C.free(unsafe.Pointer(cpath))
 	result := C.ucl_parser_set_filevars(p.parser, cpath, C.bool(expand))
 	if !result {
-		errstr := C.ucl_parser_get_error(p.parser)
-		return errors.New(C.GoString(errstr))
+		return p.parseError(filepath, nil)
 	}
 	return nil
 }
@@ -184,6 +390,7 @@ func (p *Parser) SetFileVariables(filepath string, expand bool) error {
 // RegisterVariable adds a new variable to the parser, which can be accessed in
 // the configuration file as $variable_name
 func (p *Parser) RegisterVariable(variable, value string) {
+	defer runtime.KeepAlive(p)
 	cVariable := C.CString(variable)
 	defer C.free(unsafe.Pointer(cVariable))
 	cValue := C.CString(value)
@@ -206,11 +413,11 @@ func (p *Parser) AddFileAndSetVariables(path string, expand bool) error {
 // AddOpenFile reads in the configuration from a file already opened using os.Open
 // or a related function.
 func (p *Parser) AddOpenFile(f *os.File) error {
+	defer runtime.KeepAlive(p)
 	fd := f.Fd()
 	result := C.ucl_parser_add_fd(p.parser, C.int(fd))
 	if !result {
-		errstr := C.ucl_parser_get_error(p.parser)
-		return errors.New(C.GoString(errstr))
+		return p.parseError(f.Name(), nil)
 	}
-	return nil
+	return p.variableErr(f.Name(), nil)
 }
@@ -3,6 +3,7 @@ package libucl
 import (
 	"io/ioutil"
 	"path"
+	"strings"
 	"testing"
 )
 
@@ -128,6 +129,22 @@ func TestParserRegisterMacro(t *testing.T) {
 	}
 }
 
+func TestParserRegisterMacro_false(t *testing.T) {
+	macro := func(args Object, body string) bool {
+		return false
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	p.RegisterMacro("foo", macro)
+
+	// The return value of a macro doesn't fail the parse
+	if err := p.AddString(`.foo "bar"; baz = 1;`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
 func TestParseString(t *testing.T) {
 	obj, err := ParseString("foo = bar; baz = boo;")
 	if err != nil {
@@ -208,3 +225,280 @@ func TestFileVariables(t *testing.T) {
 		t.Errorf("bad: %s, expected %s", dir.ToString(), path.Dir(tf.Name()))
 	}
 }
+
+func TestParserAddChunk_msgpack(t *testing.T) {
+	obj := testParseString(t, `
+	str = "bar";
+	num = 42;
+	neg = -7;
+	float = 2.5;
+	yes = true;
+	no = false;
+	list = [1, "two", 3.5];
+	nested { foo = "bar"; }
+	`)
+	defer obj.Close()
+
+	data, err := obj.Emit(EmitMsgpack)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddChunk([]byte(data), ParseMsgpack); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := p.Object()
+	if result == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer result.Close()
+
+	types := map[string]ObjectType{
+		"str":    ObjectTypeString,
+		"num":    ObjectTypeInt,
+		"neg":    ObjectTypeInt,
+		"float":  ObjectTypeFloat,
+		"yes":    ObjectTypeBoolean,
+		"no":     ObjectTypeBoolean,
+		"list":   ObjectTypeArray,
+		"nested": ObjectTypeObject,
+	}
+	for key, expected := range types {
+		v := result.Get(key)
+		if v == nil {
+			t.Fatalf("%s: should find", key)
+		}
+		if v.Type() != expected {
+			t.Fatalf("%s: bad: %s, expected: %s", key, v.Type(), expected)
+		}
+		v.Close()
+	}
+
+	expected, err := obj.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	actual, err := result.Emit(EmitJSONCompact)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual != expected {
+		t.Fatalf("bad: %#v, expected: %#v", actual, expected)
+	}
+}
+
+func TestParserAddChunk_ucl(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddChunk([]byte("foo = bar;"), ParseUCL); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.ToString() != "bar" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+}
+
+func TestParserAddBytes(t *testing.T) {
+	data := []byte("foo = bar;")
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddBytes(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The parser must not be holding on to our buffer
+	copy(data, "xxxxxxxxxx")
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.ToString() != "bar" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+}
+
+func TestParserAddBytes_empty(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddBytes(nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestParserAddBytes_zeroCopy(t *testing.T) {
+	data := []byte("foo = bar;")
+
+	p := NewParser(ParserZeroCopy)
+	defer p.Close()
+
+	if err := p.AddBytes(data); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.ToString() != "bar" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+}
+
+func TestParserAddReader(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddReader(strings.NewReader("foo = bar; baz = boo;")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	if obj.Len() != 2 {
+		t.Fatalf("bad: %d", obj.Len())
+	}
+}
+
+func TestParserAddStringWithPriority(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddStringWithPriority("port = 80; host = a;", 0, DuplicateAppend); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority("port = 8080;", 2, DuplicateAppend); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority("port = 1;", 1, DuplicateAppend); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	port := obj.Get("port")
+	if port == nil {
+		t.Fatal("should find")
+	}
+	defer port.Close()
+	if port.Len() != 1 {
+		t.Fatalf("bad: %d", port.Len())
+	}
+	if port.ToInt() != 8080 {
+		t.Fatalf("bad: %d", port.ToInt())
+	}
+	if port.Priority() != 2 {
+		t.Fatalf("bad: %d", port.Priority())
+	}
+
+	host := obj.Get("host")
+	if host == nil {
+		t.Fatal("should find")
+	}
+	defer host.Close()
+	if host.Priority() != 0 {
+		t.Fatalf("bad: %d", host.Priority())
+	}
+}
+
+func TestParserAddStringWithPriority_error(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddStringWithPriority("port = 80;", 0, DuplicateError); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddStringWithPriority("port = 8080;", 0, DuplicateError); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestParserAddStringWithPriority_outOfRange(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+
+	err := p.AddStringWithPriority("port = 80;", 16, DuplicateAppend)
+	if err == nil || !strings.Contains(err.Error(), "priority") {
+		t.Fatalf("bad: %#v", err)
+	}
+	err = p.AddFileWithPriority("/nonexistent", 16, DuplicateAppend)
+	if err == nil || !strings.Contains(err.Error(), "priority") {
+		t.Fatalf("bad: %#v", err)
+	}
+}
+
+func TestParserAddFileWithPriority(t *testing.T) {
+	tf, err := ioutil.TempFile("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tf.Write([]byte("foo = baz;"))
+	tf.Close()
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddString("foo = bar;"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := p.AddFileWithPriority(tf.Name(), 0, DuplicateRewrite); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		t.Fatal("obj should not be nil")
+	}
+	defer obj.Close()
+
+	v := obj.Get("foo")
+	if v == nil {
+		t.Fatal("should find")
+	}
+	defer v.Close()
+	if v.Len() != 1 || v.ToString() != "baz" {
+		t.Fatalf("bad: %#v", v.ToString())
+	}
+}
@@ -0,0 +1,298 @@
+package libucl
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SchemaFor generates a draft 4 JSON schema for the type of v, which can be
+// used with Object.Validate to check a configuration before decoding it into
+// that type. Fields are named in the same way as for Decode.
+//
+// Struct fields can describe their constraints further with tags:
+//
+//	Port  int    `libucl:"port,required" schema:"minimum=1,maximum=65535"`
+//	Proto string `schema:"enum=tcp|udp" description:"Protocol to listen on"`
+//
+// The `schema` tag understands enum (values separated by |), minimum,
+// maximum, minLength, maxLength, minItems and maxItems. The `default` option
+// of the libucl tag is included as the default. Types that refer to
+// themselves are only described down to the first repetition.
+//
+// The returned object must be closed when you're done using it.
+func SchemaFor(v interface{}) (*Object, error) {
+	schema, err := schemaFor("", reflect.TypeOf(v), make(map[reflect.Type]bool))
+	if err != nil {
+		return nil, err
+	}
+
+	schema["$schema"] = "http://json-schema.org/draft-04/schema#"
+	return Encode(schema)
+}
+
+func schemaFor(name string, t reflect.Type, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	switch t {
+	case nil, objectPtrType:
+		// Anything goes
+		return map[string]interface{}{}, nil
+	case durationType:
+		// Durations can be time values such as 10s, which the validator
+		// only accepts without a type.
+		return map[string]interface{}{}, nil
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	}
+
+	// Types that decode themselves can accept anything, and those that are
+	// decoded from text take strings, as well as the values of their kind
+	// if that is a scalar.
+	ptr := reflect.PtrTo(t)
+	if ptr.Implements(unmarshalerType) {
+		return map[string]interface{}{}, nil
+	}
+	if ptr.Implements(textUnmarshalerType) {
+		switch t.Kind() {
+		case reflect.Bool:
+			return map[string]interface{}{"type": []interface{}{"string", "boolean"}}, nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return map[string]interface{}{"type": []interface{}{"string", "integer"}}, nil
+		case reflect.Float32, reflect.Float64:
+			return map[string]interface{}{"type": []interface{}{"string", "number"}}, nil
+		default:
+			return map[string]interface{}{"type": "string"}, nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Ptr:
+		return schemaFor(name, t.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}, nil
+		}
+
+		items, err := schemaFor(name+"[]", t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+
+		result := map[string]interface{}{"type": "array", "items": items}
+		if t.Kind() == reflect.Array {
+			result["minItems"] = t.Len()
+			result["maxItems"] = t.Len()
+		}
+		return result, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: map must have string keys", name)
+		}
+
+		elem, err := schemaFor(name+"[]", t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": elem}, nil
+	case reflect.Struct:
+		if visiting[t] {
+			return map[string]interface{}{"type": "object"}, nil
+		}
+
+		visiting[t] = true
+		defer delete(visiting, t)
+
+		properties := make(map[string]interface{})
+		var required []interface{}
+		if err := schemaForFields(name, t, properties, &required, visiting); err != nil {
+			return nil, err
+		}
+
+		result := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			result["required"] = required
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported type: %s", name, t.Kind())
+	}
+}
+
+// schemaForFields adds the schemas of all the fields of the struct t to
+// properties, recursing into embedded structs that are squashed.
+func schemaForFields(
+	name string, t reflect.Type, properties map[string]interface{},
+	required *[]interface{}, visiting map[reflect.Type]bool) error {
+field_loop:
+	for i := 0; i < t.NumField(); i++ {
+		fieldType := t.Field(i)
+
+		tagValue := fieldType.Tag.Get(tagName)
+		tagParts := strings.Split(tagValue, ",")
+
+		if fieldType.Anonymous {
+			fieldKind := fieldType.Type.Kind()
+			if fieldKind != reflect.Struct {
+				return fmt.Errorf(
+					"%s: unsupported type to struct: %s",
+					fieldType.Name, fieldKind)
+			}
+
+			for _, tag := range tagOptions(tagParts) {
+				if tag == "squash" {
+					if err := schemaForFields(name, fieldType.Type, properties, required, visiting); err != nil {
+						return err
+					}
+					continue field_loop
+				}
+			}
+		}
+
+		if fieldType.PkgPath != "" {
+			continue field_loop
+		}
+
+		isRequired := false
+		for _, tag := range tagOptions(tagParts) {
+			switch tag {
+			case "decodedFields", "key", "object", "unusedKeys":
+				continue field_loop
+			case "required":
+				isRequired = true
+			}
+		}
+
+		fieldName := fieldType.Name
+		if tagParts[0] != "" {
+			fieldName = tagParts[0]
+		}
+
+		qualifiedName := fieldName
+		if name != "" {
+			qualifiedName = fmt.Sprintf("%s.%s", name, fieldName)
+		}
+
+		schema, err := schemaFor(qualifiedName, fieldType.Type, visiting)
+		if err != nil {
+			return err
+		}
+
+		if desc := fieldType.Tag.Get("description"); desc != "" {
+			schema["description"] = desc
+		}
+		if value, ok := tagDefault(tagParts); ok {
+			v, err := schemaDefault(qualifiedName, fieldType.Type, value)
+			if err != nil {
+				return err
+			}
+			schema["default"] = v
+
+			// The decoder fills in a missing key from its default
+			isRequired = false
+		}
+		if err := schemaOptions(qualifiedName, fieldType, schema); err != nil {
+			return err
+		}
+
+		properties[fieldName] = schema
+		if isRequired {
+			*required = append(*required, fieldName)
+		}
+	}
+
+	return nil
+}
+
+// schemaOptions adds the constraints from the `schema` tag of a field.
+func schemaOptions(name string, field reflect.StructField, schema map[string]interface{}) error {
+	tagValue := field.Tag.Get("schema")
+	if tagValue == "" {
+		return nil
+	}
+
+	for _, option := range strings.Split(tagValue, ",") {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s: invalid schema option: %s", name, option)
+		}
+
+		key, value := parts[0], parts[1]
+		switch key {
+		case "enum":
+			var values []interface{}
+			for _, s := range strings.Split(value, "|") {
+				v, err := schemaScalar(field.Type, s)
+				if err != nil {
+					return fmt.Errorf("%s: invalid enum value: %s", name, err)
+				}
+				values = append(values, v)
+			}
+			schema["enum"] = values
+		case "minimum", "maximum":
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%s: invalid %s: %s", name, key, err)
+			}
+			schema[key] = v
+		case "minLength", "maxLength", "minItems", "maxItems":
+			v, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("%s: invalid %s: %s", name, key, err)
+			}
+			schema[key] = v
+		default:
+			return fmt.Errorf("%s: unknown schema option: %s", name, key)
+		}
+	}
+
+	return nil
+}
+
+// schemaDefault decodes the `default` option of a field in the same way as
+// Decode, so that it is encoded like any other value of the field.
+func schemaDefault(name string, t reflect.Type, value string) (interface{}, error) {
+	result := reflect.New(t)
+	d, err := NewDecoder(&DecoderConfig{Result: result.Interface()})
+	if err != nil {
+		return nil, err
+	}
+
+	d.decodeTagValue(name, value, result.Elem(), false)
+	if len(d.errors) > 0 {
+		return nil, &DecodeError{Errors: d.errors}
+	}
+	return result.Elem().Interface(), nil
+}
+
+// schemaScalar converts s into a value of the same kind as t.
+func schemaScalar(t reflect.Type, s string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(s, 0, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.ParseUint(s, 0, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(s, 64)
+	default:
+		return s, nil
+	}
+}
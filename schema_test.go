@@ -0,0 +1,160 @@
+package libucl
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testSchemaConfig struct {
+	Name    string `libucl:"name,required" description:"Name of the service"`
+	Proto   string `libucl:"proto" schema:"enum=tcp|udp"`
+	Workers uint   `libucl:"workers,required,default=4"`
+	Servers []struct {
+		Port int `libucl:"port,required" schema:"minimum=1,maximum=65535"`
+	} `libucl:"servers"`
+	Labels map[string]string `libucl:"labels"`
+
+	Key    string `libucl:",key"`
+	hidden string
+}
+
+func TestSchemaFor(t *testing.T) {
+	schema, err := SchemaFor(&testSchemaConfig{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer schema.Close()
+
+	var s map[string]interface{}
+	if err := schema.Decode(&s); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if s["type"] != "object" {
+		t.Fatalf("bad: %#v", s)
+	}
+
+	props := s["properties"].([]map[string]interface{})[0]
+	for _, k := range []string{"Key", "hidden"} {
+		if _, ok := props[k]; ok {
+			t.Fatalf("should not have %s: %#v", k, props)
+		}
+	}
+
+	name := props["name"].([]map[string]interface{})[0]
+	if name["type"] != "string" || name["description"] != "Name of the service" {
+		t.Fatalf("bad: %#v", name)
+	}
+
+	proto := props["proto"].([]map[string]interface{})[0]
+	if !reflect.DeepEqual(proto["enum"], []interface{}{"tcp", "udp"}) {
+		t.Fatalf("bad: %#v", proto)
+	}
+
+	workers := props["workers"].([]map[string]interface{})[0]
+	if workers["default"] != 4 {
+		t.Fatalf("bad: %#v", workers)
+	}
+
+	if !reflect.DeepEqual(s["required"], []interface{}{"name"}) {
+		t.Fatalf("bad: %#v", s["required"])
+	}
+}
+
+func TestSchemaFor_validate(t *testing.T) {
+	schema, err := SchemaFor(testSchemaConfig{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer schema.Close()
+
+	cases := []struct {
+		Input string
+		Err   bool
+	}{
+		{`name = "foo"; proto = "tcp"; servers = [{ port = 80 }]`, false},
+		{`proto = "tcp"`, true},
+		{`name = "foo"; proto = "icmp"`, true},
+		{`name = "foo"; servers = [{ port = 0 }]`, true},
+		{`name = "foo"; servers = [{}]`, true},
+		{`name = "foo"; workers = -1`, true},
+		{`name = "foo"; labels { a = "b" }`, false},
+	}
+
+	for _, tc := range cases {
+		obj := testParseString(t, tc.Input)
+		_, err := obj.Validate(schema)
+		obj.Close()
+		if (err != nil) != tc.Err {
+			t.Fatalf("input: %s\nerr: %v", tc.Input, err)
+		}
+	}
+}
+
+func TestSchemaFor_textUnmarshaler(t *testing.T) {
+	type Config struct {
+		Level testLevel `libucl:"level"`
+	}
+
+	schema, err := SchemaFor(Config{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer schema.Close()
+
+	cases := []struct {
+		Input string
+		Err   bool
+	}{
+		{`level = "low"`, false},
+		{`level = 2`, false},
+		{`level = true`, true},
+	}
+
+	for _, tc := range cases {
+		obj := testParseString(t, tc.Input)
+		_, err := obj.Validate(schema)
+		obj.Close()
+		if (err != nil) != tc.Err {
+			t.Fatalf("input: %s\nerr: %v", tc.Input, err)
+		}
+	}
+}
+
+func TestSchemaFor_recursive(t *testing.T) {
+	type Node struct {
+		Name     string  `libucl:"name"`
+		Children []*Node `libucl:"children"`
+	}
+
+	schema, err := SchemaFor(Node{})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	schema.Close()
+}
+
+func TestSchemaFor_invalid(t *testing.T) {
+	cases := []interface{}{
+		struct{ C chan int }{},
+		struct{ M map[int]string }{},
+		struct {
+			Port int `schema:"minimum=low"`
+		}{},
+		struct {
+			Port int `schema:"enum=1|two"`
+		}{},
+		struct {
+			Port int `schema:"bogus=1"`
+		}{},
+		struct {
+			Port int `libucl:"port,default=low"`
+		}{},
+	}
+
+	for _, tc := range cases {
+		if _, err := SchemaFor(tc); err == nil {
+			t.Fatalf("should error: %#v", tc)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package libucl
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// SchemaSet is a collection of schemas, identified by ID, which can refer to
+// each other with `$ref`. A reference such as "common.json#/definitions/port"
+// is resolved against the schema with the ID "common.json". References to
+// schemas that aren't in the set are loaded by libucl from the filesystem or
+// a URL, and kept for later validations.
+//
+// A SchemaSet is safe for concurrent use.
+type SchemaSet struct {
+	lock sync.Mutex
+
+	// The schemas by ID, which is also how libucl looks up external
+	// references.
+	refs *C.ucl_object_t
+}
+
+// NewSchemaSet returns an empty SchemaSet. It must be closed when you're done
+// using it.
+func NewSchemaSet() *SchemaSet {
+	return &SchemaSet{
+		refs: C.ucl_object_typed_new(C.UCL_OBJECT),
+	}
+}
+
+// Close frees all the schemas in the set.
+func (s *SchemaSet) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	C.ucl_object_unref(s.refs)
+	s.refs = nil
+}
+
+// Add adds a schema to the set under the given ID, replacing any schema that
+// already has that ID. The set keeps its own copy of the schema.
+func (s *SchemaSet) Add(id string, schema *Object) error {
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	obj := C.ucl_object_copy(schema.object)
+	runtime.KeepAlive(schema)
+	if !C.ucl_object_replace_key(s.refs, obj, cid, C.size_t(len(id)), true) {
+		C.ucl_object_unref(obj)
+		return fmt.Errorf("cannot add schema %s", id)
+	}
+	return nil
+}
+
+// AddFile parses a UCL or JSON schema from a file and adds it to the set
+// under the given ID. Files that have already been added under the same ID
+// aren't parsed again.
+func (s *SchemaSet) AddFile(id, path string) error {
+	if s.Has(id) {
+		return nil
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+
+	if err := p.AddFile(path); err != nil {
+		return err
+	}
+
+	schema := p.Object()
+	if schema == nil {
+		return fmt.Errorf("%s: empty schema", path)
+	}
+	defer schema.Close()
+
+	return s.Add(id, schema)
+}
+
+// Has returns whether the set has a schema with the given ID.
+func (s *SchemaSet) Has(id string) bool {
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return C.ucl_object_find_keyl(s.refs, cid, C.size_t(len(id))) != nil
+}
+
+// Get returns the schema with the given ID, or nil if there is no such
+// schema. The returned object must be closed.
+func (s *SchemaSet) Get(id string) *Object {
+	cid := C.CString(id)
+	defer C.free(unsafe.Pointer(cid))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	obj := C.ucl_object_find_keyl(s.refs, cid, C.size_t(len(id)))
+	if obj == nil {
+		return nil
+	}
+
+	result := newObject(obj)
+	result.Ref()
+	return result
+}
+
+// Validate validates obj against the schema with the given ID, resolving any
+// references to other schemas in the set. See Object.Validate.
+func (s *SchemaSet) Validate(id string, obj *Object) (SchemaError, error) {
+	schema := s.Get(id)
+	if schema == nil {
+		err := SchemaError{
+			code:    SchemaExternalRefMissing,
+			message: fmt.Sprintf("unknown schema: %s", id),
+		}
+		return err, err
+	}
+	defer schema.Close()
+
+	// libucl adds any schemas it has to load itself to the set
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	defer runtime.KeepAlive(obj)
+
+	var cError C.ucl_schema_error_t
+	var err error
+	var schemaError SchemaError
+	ok := C.ucl_object_validate_root_ext(
+		schema.object, obj.object, schema.object, s.refs, &cError)
+	if !ok {
+		schemaError = newSchemaError(&cError, obj.object, "")
+		err = schemaError
+	}
+	return schemaError, err
+}
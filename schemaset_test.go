@@ -0,0 +1,119 @@
+package libucl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testSchemaSet(t *testing.T) *SchemaSet {
+	dir, err := ioutil.TempDir("", "libucl")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"common.json": `{
+			"definitions": {
+				"port": { "type": "integer", "minimum": 1, "maximum": 65535 }
+			}
+		}`,
+		"server.ucl": `
+			type = "object";
+			properties {
+				port { "$ref" = "common.json#/definitions/port"; }
+			}
+			required = ["port"];
+		`,
+	}
+
+	s := NewSchemaSet()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if err := s.AddFile(name, path); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	return s
+}
+
+func TestSchemaSetValidate(t *testing.T) {
+	s := testSchemaSet(t)
+	defer s.Close()
+
+	obj := testParseString(t, "port = 8080;")
+	defer obj.Close()
+
+	if _, err := s.Validate("server.ucl", obj); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestSchemaSetValidate_refFails(t *testing.T) {
+	s := testSchemaSet(t)
+	defer s.Close()
+
+	obj := testParseString(t, "port = 70000;")
+	defer obj.Close()
+
+	schemaErr, err := s.Validate("server.ucl", obj)
+	if err == nil {
+		t.Fatal("should fail")
+	}
+	if schemaErr.Code() != SchemaConstraint {
+		t.Fatalf("bad: %s", schemaErr.Code())
+	}
+	if schemaErr.Path() != "/port" {
+		t.Fatalf("bad: %#v", schemaErr.Path())
+	}
+}
+
+func TestSchemaSetValidate_unknown(t *testing.T) {
+	s := NewSchemaSet()
+	defer s.Close()
+
+	obj := testParseString(t, "port = 8080;")
+	defer obj.Close()
+
+	if _, err := s.Validate("missing", obj); err == nil {
+		t.Fatal("should fail")
+	}
+}
+
+func TestSchemaSetAdd(t *testing.T) {
+	s := NewSchemaSet()
+	defer s.Close()
+
+	schema := testParseString(t, `{ "type": "string" }`)
+	defer schema.Close()
+
+	if err := s.Add("string", schema); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !s.Has("string") {
+		t.Fatal("should have schema")
+	}
+
+	// Files already in the set are not loaded again
+	if err := s.AddFile("string", "/nonexistent"); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	got := s.Get("string")
+	if got == nil {
+		t.Fatal("should find")
+	}
+	defer got.Close()
+
+	obj := NewIntegerObject(42)
+	defer obj.Close()
+	if _, err := s.Validate("string", obj); err == nil {
+		t.Fatal("should fail")
+	}
+}
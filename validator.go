@@ -1,6 +1,12 @@
 package libucl
 
-import "errors"
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
 
 // #include "go-libucl.h"
 import "C"
@@ -10,46 +16,560 @@ type SchemaErrorCode int
 
 const (
 	// SchemaOK means nothing went wrong (no error)
-	SchemaOK SchemaErrorCode = iota
+	SchemaOK SchemaErrorCode = C.UCL_SCHEMA_OK
 	// SchemaTypeMismatch means the type of object is wrong
-	SchemaTypeMismatch
+	SchemaTypeMismatch SchemaErrorCode = C.UCL_SCHEMA_TYPE_MISMATCH
 	// SchemaInvalidSchema means the provided schema is not valid according to
 	// json-schema draft 4
-	SchemaInvalidSchema
+	SchemaInvalidSchema SchemaErrorCode = C.UCL_SCHEMA_INVALID_SCHEMA
 	// SchemaMissingProperty means at least one property of the object is missing
-	SchemaMissingProperty
+	SchemaMissingProperty SchemaErrorCode = C.UCL_SCHEMA_MISSING_PROPERTY
 	// SchemaConstraint means a contraint was not met.
-	SchemaConstraint
+	SchemaConstraint SchemaErrorCode = C.UCL_SCHEMA_CONSTRAINT
 	// SchemaMissingDependency means a dependency was not met
-	SchemaMissingDependency
+	SchemaMissingDependency SchemaErrorCode = C.UCL_SCHEMA_MISSING_DEPENDENCY
+	// SchemaExternalRefMissing means an external $ref could not be loaded
+	SchemaExternalRefMissing SchemaErrorCode = C.UCL_SCHEMA_EXTERNAL_REF_MISSING
+	// SchemaExternalRefInvalid means an external $ref is not a valid schema
+	SchemaExternalRefInvalid SchemaErrorCode = C.UCL_SCHEMA_EXTERNAL_REF_INVALID
+	// SchemaInternalError means libucl hit an internal error
+	SchemaInternalError SchemaErrorCode = C.UCL_SCHEMA_INTERNAL_ERROR
 	// SchemaGenericError is a generic error (matches UCL_SCHEMA_UNKNOWN in libucl)
-	SchemaGenericError
+	SchemaGenericError SchemaErrorCode = C.UCL_SCHEMA_UNKNOWN
 )
 
+var schemaErrorCodeNames = map[SchemaErrorCode]string{
+	SchemaOK:                 "ok",
+	SchemaTypeMismatch:       "type mismatch",
+	SchemaInvalidSchema:      "invalid schema",
+	SchemaMissingProperty:    "missing property",
+	SchemaConstraint:         "constraint",
+	SchemaMissingDependency:  "missing dependency",
+	SchemaExternalRefMissing: "external ref missing",
+	SchemaExternalRefInvalid: "external ref invalid",
+	SchemaInternalError:      "internal error",
+	SchemaGenericError:       "generic error",
+}
+
+// String returns a short description of the error code.
+func (c SchemaErrorCode) String() string {
+	if name, ok := schemaErrorCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("SchemaErrorCode(%d)", int(c))
+}
+
 // SchemaError contains information on an error found when validating an UCL Object
 // against a provided json-schema style schema
 type SchemaError struct {
 	code    SchemaErrorCode
 	message string
 	object  *Object
+	path    string
+}
+
+// Code returns what kind of error this is.
+func (e SchemaError) Code() SchemaErrorCode {
+	return e.code
+}
+
+// Message returns the error message reported by libucl.
+func (e SchemaError) Message() string {
+	return e.message
+}
+
+// Object returns the node that failed validation, or nil if it isn't known.
+// The returned object must be closed.
+func (e SchemaError) Object() *Object {
+	if e.object == nil {
+		return nil
+	}
+
+	e.object.Ref()
+	defer runtime.KeepAlive(e.object)
+	return newObject(e.object.object)
+}
+
+// Path returns a JSON pointer (RFC 6901) from the root of the validated
+// object to the node that failed validation, such as "/servers/0/port".
+// The root itself is the empty string.
+func (e SchemaError) Path() string {
+	return e.path
+}
+
+// Error returns the error message reported by libucl.
+func (e SchemaError) Error() string {
+	return e.message
+}
+
+// SchemaErrors is every error found by ValidateAll.
+type SchemaErrors []SchemaError
+
+// Error returns every error message on its own line, prefixed with the path
+// to the node that failed validation.
+func (e SchemaErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		if err.path == "" {
+			lines[i] = err.message
+		} else {
+			lines[i] = fmt.Sprintf("%s: %s", err.path, err.message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Unwrap returns every error, for use with errors.Is and errors.As.
+func (e SchemaErrors) Unwrap() []error {
+	result := make([]error, len(e))
+	for i, err := range e {
+		result[i] = err
+	}
+	return result
 }
 
 // Validate validates the object againt a provided schema, which should conform to
 // the 4th draft of the json-schema standard
 func (o *Object) Validate(schema *Object) (SchemaError, error) {
+	defer runtime.KeepAlive(o)
+	defer runtime.KeepAlive(schema)
 	var cError C.ucl_schema_error_t
 	var err error
 	var schemaError SchemaError
 	ok := C.ucl_object_validate(schema.object, o.object, &cError)
 	if !ok {
-		schemaError.code = SchemaErrorCode(cError.code)
-		schemaError.message = bufferToString(cError.msg)
-		schemaError.object = &Object{object: cError.obj}
-		err = errors.New(schemaError.message)
+		schemaError = newSchemaError(&cError, o.object, "")
+		err = schemaError
 	}
 	return schemaError, err
 }
 
+// ValidateAll validates the object against a provided schema like Validate,
+// but rather than stopping at the first error it keeps walking the object
+// and validates every property and array item against its part of the
+// schema. If anything fails, the returned error is a SchemaErrors.
+func (o *Object) ValidateAll(schema *Object) error {
+	var errs SchemaErrors
+	validateAll(schema, schema, o, "", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func newSchemaError(cError *C.ucl_schema_error_t, root *C.ucl_object_t, path string) SchemaError {
+	e := SchemaError{
+		code:    SchemaErrorCode(cError.code),
+		message: bufferToString(cError.msg),
+		path:    path,
+	}
+	if cError.obj != nil {
+		// The error has no Close, so its reference to the node, which keeps
+		// it valid after the validated object is closed, is dropped when it
+		// is garbage collected.
+		C.ucl_object_ref(cError.obj)
+		e.object = &Object{object: cError.obj, autoClose: true}
+		runtime.SetFinalizer(e.object, (*Object).Close)
+		if p, ok := pointerTo(root, cError.obj); ok {
+			e.path = path + p
+		}
+	}
+	return e
+}
+
+// validateAll validates obj against only the top level of schema, then does
+// the same for each of its children against their own part of the schema.
+func validateAll(root, schema, obj *Object, path string, errs *SchemaErrors) {
+	schema = resolveRef(root, schema)
+	defer schema.Close()
+
+	shallow := shallowSchema(root, schema)
+	var cError C.ucl_schema_error_t
+	ok := C.ucl_object_validate_root(shallow.object, obj.object, root.object, &cError)
+	runtime.KeepAlive(obj)
+	runtime.KeepAlive(root)
+	if !ok {
+		e := newSchemaError(&cError, obj.object, path)
+
+		// We can't walk an invalid schema, and the error refers to our
+		// copy of it.
+		if e.code == SchemaInvalidSchema {
+			e.object = nil
+			errs.add(e)
+			shallow.Close()
+			return
+		}
+		errs.add(e)
+	}
+	shallow.Close()
+
+	// The branches of an allOf apply to the children too. Those of the
+	// other combinators were validated in full by libucl, so they aren't
+	// walked again.
+	schemas := allOf(root, schema)
+	for _, s := range schemas {
+		switch obj.Type() {
+		case ObjectTypeObject:
+			validateAllProperties(root, s, obj, path, errs)
+		case ObjectTypeArray:
+			validateAllItems(root, s, obj, path, errs)
+		}
+		s.Close()
+	}
+}
+
+// add adds e, unless the same error was already found through another part
+// of the schema.
+func (errs *SchemaErrors) add(e SchemaError) {
+	for _, other := range *errs {
+		if other.path == e.path && other.code == e.code && other.message == e.message {
+			return
+		}
+	}
+	*errs = append(*errs, e)
+}
+
+// allOf returns schema and the branches of its allOf, recursively, with
+// their references resolved. They must all be closed.
+func allOf(root, schema *Object) []*Object {
+	schema.Ref()
+	result := []*Object{newObject(schema.object)}
+
+	branches := schema.Get("allOf")
+	if branches == nil {
+		return result
+	}
+	defer branches.Close()
+
+	iter := branches.Iterate(true)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		if elem.Type() == ObjectTypeObject {
+			branch := resolveRef(root, elem)
+			result = append(result, allOf(root, branch)...)
+			branch.Close()
+		}
+		elem.Close()
+	}
+	return result
+}
+
+// maxRefDepth is how many references resolveRef follows before giving up,
+// so that a reference to itself can't loop forever.
+const maxRefDepth = 32
+
+// resolveRef returns the schema referred to by the `$ref` of schema, if it
+// has one that points into root, and otherwise schema itself. The result
+// must be closed. References to other documents are left to libucl.
+func resolveRef(root, schema *Object) *Object {
+	schema.Ref()
+	result := newObject(schema.object)
+
+	for i := 0; i < maxRefDepth && result.Type() == ObjectTypeObject; i++ {
+		ref := result.Get("$ref")
+		if ref == nil {
+			break
+		}
+		pointer := ref.ToString()
+		ref.Close()
+
+		if !strings.HasPrefix(pointer, "#") {
+			break
+		}
+		target := lookupPointer(root, pointer[1:])
+		if target == nil {
+			// libucl reports it when validating
+			break
+		}
+
+		result.Close()
+		result = target
+	}
+
+	return result
+}
+
+// lookupPointer returns the value at the JSON pointer in obj, or nil if
+// there isn't one. The result must be closed.
+func lookupPointer(obj *Object, pointer string) *Object {
+	obj.Ref()
+	result := newObject(obj.object)
+	if pointer == "" {
+		return result
+	}
+
+	unescape := strings.NewReplacer("~1", "/", "~0", "~")
+	for _, part := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		var next *Object
+		switch result.Type() {
+		case ObjectTypeObject:
+			next = result.Get(unescape.Replace(part))
+		case ObjectTypeArray:
+			if i, err := strconv.Atoi(part); err == nil {
+				next = result.Index(i)
+			}
+		}
+
+		result.Close()
+		if next == nil {
+			return nil
+		}
+		result = next
+	}
+
+	return result
+}
+
+func validateAllProperties(root, schema, obj *Object, path string, errs *SchemaErrors) {
+	var patterns []*regexp.Regexp
+	var patternSchemas []*Object
+	if patternProps := schema.Get("patternProperties"); patternProps != nil {
+		iter := patternProps.Iterate(true)
+		for elem := iter.Next(); elem != nil; elem = iter.Next() {
+			re, err := regexp.Compile(elem.Key())
+			if err != nil {
+				elem.Close()
+				continue
+			}
+			patterns = append(patterns, re)
+			patternSchemas = append(patternSchemas, elem)
+		}
+		iter.Close()
+		patternProps.Close()
+	}
+	defer func() {
+		for _, s := range patternSchemas {
+			s.Close()
+		}
+	}()
+
+	props := schema.Get("properties")
+	if props != nil {
+		defer props.Close()
+	}
+	additional := schema.Get("additionalProperties")
+	if additional != nil {
+		defer additional.Close()
+	}
+
+	iter := obj.Iterate(true)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		key := elem.Key()
+		elemPath := path + "/" + escapePointer(key)
+
+		matched := false
+		if props != nil {
+			if sub := props.Get(key); sub != nil {
+				matched = true
+				validateAllValues(root, sub, elem, elemPath, errs)
+				sub.Close()
+			}
+		}
+		for i, re := range patterns {
+			if re.MatchString(key) {
+				matched = true
+				validateAllValues(root, patternSchemas[i], elem, elemPath, errs)
+			}
+		}
+		if !matched && additional != nil && additional.Type() == ObjectTypeObject {
+			validateAllValues(root, additional, elem, elemPath, errs)
+		}
+
+		elem.Close()
+	}
+}
+
+// validateAllValues validates every value of an implicit array.
+func validateAllValues(root, schema, obj *Object, path string, errs *SchemaErrors) {
+	if obj.object.next == nil {
+		validateAll(root, schema, obj, path, errs)
+		return
+	}
+
+	i := 0
+	iter := obj.Iterate(false)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		validateAll(root, schema, elem, path+"/"+strconv.Itoa(i), errs)
+		elem.Close()
+		i++
+	}
+}
+
+func validateAllItems(root, schema, obj *Object, path string, errs *SchemaErrors) {
+	items := schema.Get("items")
+	if items == nil {
+		return
+	}
+	defer items.Close()
+
+	additional := schema.Get("additionalItems")
+	if additional != nil {
+		defer additional.Close()
+	}
+
+	i := 0
+	iter := obj.Iterate(true)
+	defer iter.Close()
+	for elem := iter.Next(); elem != nil; elem = iter.Next() {
+		elemPath := path + "/" + strconv.Itoa(i)
+
+		switch items.Type() {
+		case ObjectTypeObject:
+			validateAll(root, items, elem, elemPath, errs)
+		case ObjectTypeArray:
+			if sub := items.Index(i); sub != nil {
+				validateAll(root, sub, elem, elemPath, errs)
+				sub.Close()
+			} else if additional != nil && additional.Type() == ObjectTypeObject {
+				validateAll(root, additional, elem, elemPath, errs)
+			}
+		}
+
+		elem.Close()
+		i++
+	}
+}
+
+// shallowSchema returns a copy of schema where the schemas of properties and
+// array items are empty, so it only validates the top level of an object.
+// The branches of an allOf are made shallow in the same way.
+func shallowSchema(root, schema *Object) *Object {
+	result := schema.Copy()
+	if result.Type() != ObjectTypeObject {
+		return result
+	}
+
+	if branches := result.Get("allOf"); branches != nil {
+		if branches.Type() == ObjectTypeArray {
+			for i := 0; i < int(branches.Len()); i++ {
+				branch := branches.Index(i)
+				if branch == nil {
+					continue
+				}
+
+				if branch.Type() == ObjectTypeObject {
+					resolved := resolveRef(root, branch)
+					shallow := shallowSchema(root, resolved)
+					if old, err := branches.Replace(i, shallow); err == nil {
+						old.Close()
+					}
+					shallow.Close()
+					resolved.Close()
+				}
+				branch.Close()
+			}
+		}
+		branches.Close()
+	}
+
+	for _, key := range []string{"properties", "patternProperties"} {
+		if props := result.Get(key); props != nil {
+			var keys []string
+			iter := props.Iterate(true)
+			for elem := iter.Next(); elem != nil; elem = iter.Next() {
+				keys = append(keys, elem.Key())
+				elem.Close()
+			}
+			iter.Close()
+
+			for _, k := range keys {
+				empty := NewObjectMap()
+				props.Set(k, empty)
+				empty.Close()
+			}
+			props.Close()
+		}
+	}
+
+	for _, key := range []string{"additionalProperties", "additionalItems"} {
+		if v := result.Get(key); v != nil {
+			if v.Type() == ObjectTypeObject {
+				empty := NewObjectMap()
+				result.Set(key, empty)
+				empty.Close()
+			}
+			v.Close()
+		}
+	}
+
+	if items := result.Get("items"); items != nil {
+		switch items.Type() {
+		case ObjectTypeObject:
+			empty := NewObjectMap()
+			result.Set("items", empty)
+			empty.Close()
+		case ObjectTypeArray:
+			for i := 0; i < int(items.Len()); i++ {
+				empty := NewObjectMap()
+				if old, err := items.Replace(i, empty); err == nil {
+					old.Close()
+				}
+				empty.Close()
+			}
+		}
+		items.Close()
+	}
+
+	return result
+}
+
+// pointerTo returns the JSON pointer from root to target, and whether target
+// was found at all.
+func pointerTo(root, target *C.ucl_object_t) (string, bool) {
+	if root == target {
+		return "", true
+	}
+
+	// Iteration always runs to the end, so libucl frees its iterator
+	var result string
+	found := false
+	var iter C.ucl_object_iter_t
+	switch ObjectType(C.ucl_object_type(root)) {
+	case ObjectTypeObject:
+		for elt := C.ucl_object_iterate_with_error(root, &iter, true, nil); elt != nil; elt = C.ucl_object_iterate_with_error(root, &iter, true, nil) {
+			if found {
+				continue
+			}
+
+			key := "/" + escapePointer(C.GoString(C.ucl_object_key(elt)))
+			if elt.next == nil {
+				if p, ok := pointerTo(elt, target); ok {
+					result, found = key+p, true
+				}
+				continue
+			}
+
+			// Implicit arrays are indexed like explicit ones
+			i := 0
+			for cur := elt; cur != nil && !found; cur = cur.next {
+				if p, ok := pointerTo(cur, target); ok {
+					result, found = key+"/"+strconv.Itoa(i)+p, true
+				}
+				i++
+			}
+		}
+	case ObjectTypeArray:
+		i := 0
+		for elt := C.ucl_object_iterate_with_error(root, &iter, true, nil); elt != nil; elt = C.ucl_object_iterate_with_error(root, &iter, true, nil) {
+			if !found {
+				if p, ok := pointerTo(elt, target); ok {
+					result, found = "/"+strconv.Itoa(i)+p, true
+				}
+			}
+			i++
+		}
+	}
+
+	return result, found
+}
+
+// escapePointer escapes a key for use in a JSON pointer.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
 // Convert a fixed char array to a go string, as gGo doesn't let us use
 // [128]C.char as *C.char in C.GoString
 func bufferToString(buffer [128]C.char) string {
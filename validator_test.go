@@ -1,6 +1,8 @@
 package libucl
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -12,3 +14,198 @@ func TestBufferToString(t *testing.T) {
 		t.Fatalf("bad: \"%s\", expected: \"%s\"", str, expected)
 	}
 }
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"name": { "type": "string" },
+		"servers": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"properties": {
+					"port": { "type": "integer", "maximum": 65535 }
+				},
+				"required": ["port"]
+			}
+		}
+	},
+	"required": ["name"]
+}`
+
+func TestObjectValidate(t *testing.T) {
+	schema := testParseString(t, testSchema)
+	defer schema.Close()
+
+	obj := testParseString(t, `name = "foo"; servers = [{ port = 80; }, { port = "bar"; }]`)
+	defer obj.Close()
+
+	schemaErr, err := obj.Validate(schema)
+	if err == nil {
+		t.Fatal("should fail")
+	}
+
+	var e SchemaError
+	if !errors.As(err, &e) {
+		t.Fatalf("bad: %#v", err)
+	}
+	if e.Code() != SchemaTypeMismatch || schemaErr.Code() != SchemaTypeMismatch {
+		t.Fatalf("bad: %s", e.Code())
+	}
+	if e.Message() == "" {
+		t.Fatal("should have message")
+	}
+	if e.Path() != "/servers/1/port" {
+		t.Fatalf("bad: %#v", e.Path())
+	}
+
+	failed := e.Object()
+	if failed == nil {
+		t.Fatal("should have object")
+	}
+	defer failed.Close()
+	if failed.ToString() != "bar" {
+		t.Fatalf("bad: %#v", failed.ToString())
+	}
+}
+
+func TestObjectValidate_ok(t *testing.T) {
+	schema := testParseString(t, testSchema)
+	defer schema.Close()
+
+	obj := testParseString(t, `name = "foo"; servers = [{ port = 80; }]`)
+	defer obj.Close()
+
+	if _, err := obj.Validate(schema); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := obj.ValidateAll(schema); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestObjectValidateAll(t *testing.T) {
+	schema := testParseString(t, testSchema)
+	defer schema.Close()
+
+	obj := testParseString(t, `
+	servers = [
+		{ port = "bar"; },
+		{ },
+		{ port = 70000; },
+	]`)
+	defer obj.Close()
+
+	err := obj.ValidateAll(schema)
+	var errs SchemaErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	expected := []struct {
+		Path string
+		Code SchemaErrorCode
+	}{
+		{"", SchemaMissingProperty},
+		{"/servers/0/port", SchemaTypeMismatch},
+		{"/servers/1", SchemaMissingProperty},
+		{"/servers/2/port", SchemaConstraint},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("bad: %s", err)
+	}
+	for i, e := range expected {
+		if errs[i].Path() != e.Path || errs[i].Code() != e.Code {
+			t.Fatalf("%d: bad: %s %s", i, errs[i].Path(), errs[i].Code())
+		}
+	}
+
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != len(expected) {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestObjectValidateAll_ref(t *testing.T) {
+	schema := testParseString(t, `{
+		"definitions": {
+			"server": {
+				"type": "object",
+				"properties": {
+					"host": { "type": "string" },
+					"port": { "type": "integer" }
+				}
+			}
+		},
+		"type": "object",
+		"properties": {
+			"server": { "$ref": "#/definitions/server" }
+		}
+	}`)
+	defer schema.Close()
+
+	obj := testParseString(t, `server { host = 1; port = "bar"; }`)
+	defer obj.Close()
+
+	err := obj.ValidateAll(schema)
+	var errs SchemaErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	expected := []string{"/server/host", "/server/port"}
+	if len(errs) != len(expected) {
+		t.Fatalf("bad: %s", err)
+	}
+	for i, path := range expected {
+		if errs[i].Path() != path || errs[i].Code() != SchemaTypeMismatch {
+			t.Fatalf("%d: bad: %s %s", i, errs[i].Path(), errs[i].Code())
+		}
+	}
+}
+
+func TestObjectValidateAll_allOf(t *testing.T) {
+	schema := testParseString(t, `{
+		"type": "object",
+		"properties": {
+			"port": { "type": "integer" }
+		},
+		"allOf": [
+			{
+				"properties": {
+					"port": { "type": "integer" },
+					"host": { "type": "string" }
+				}
+			}
+		]
+	}`)
+	defer schema.Close()
+
+	obj := testParseString(t, `host = 1; port = "bar";`)
+	defer obj.Close()
+
+	err := obj.ValidateAll(schema)
+	var errs SchemaErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("bad: %#v", err)
+	}
+
+	// Each error is only reported once
+	if len(errs) != 2 {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestSchemaErrorCodeString(t *testing.T) {
+	if SchemaMissingProperty.String() != "missing property" {
+		t.Fatalf("bad: %s", SchemaMissingProperty.String())
+	}
+	if SchemaErrorCode(42).String() != "SchemaErrorCode(42)" {
+		t.Fatalf("bad: %s", SchemaErrorCode(42).String())
+	}
+}
+
+func TestEscapePointer(t *testing.T) {
+	if escapePointer("a/b~c") != "a~1b~0c" {
+		t.Fatalf("bad: %s", escapePointer("a/b~c"))
+	}
+}
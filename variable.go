@@ -0,0 +1,189 @@
+package libucl
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// #include "go-libucl.h"
+import "C"
+
+// VariableHandler is the callback type for resolving variables that weren't
+// registered with RegisterVariable. It is given the name of the variable and
+// returns its value, and whether it has one.
+type VariableHandler func(name string) (string, bool)
+
+// Keeps track of the variable handlers of all the parsers
+var variableHandlers map[int]*variableState
+var variableHandlersIdx int
+var variableHandlersLock sync.Mutex
+
+// variableState is what the parser's libucl variable handler calls into.
+type variableState struct {
+	handler VariableHandler
+	strict  bool
+	parser  *C.struct_ucl_parser
+
+	// libucl asks for a value twice, once to size the result and again to
+	// copy it, so the answers have to stay the same.
+	values map[string]variableValue
+
+	// The first unknown variable in strict mode, and libucl's current file
+	// when it was found
+	err     *ParseError
+	errFile string
+
+	// The file being included, if any, which an error is reported in
+	include *IncludeFile
+}
+
+type variableValue struct {
+	value string
+	ok    bool
+}
+
+// SetVariableHandler makes the parser call h for every variable that it
+// doesn't know, which lets variables be looked up lazily from the
+// environment or anywhere else. The handler is called once for each name:
+// its answers are kept for the life of the parser and never reset, so data
+// added later sees the same values even if the handler's would change.
+//
+// libucl only calls the handler for variables written as ${NAME}; an
+// unknown $NAME is always left as it is.
+func (p *Parser) SetVariableHandler(h VariableHandler) {
+	p.variableState().handler = h
+}
+
+// SetStrictVariables makes it a parse error to use a variable written as
+// ${NAME} that is neither registered nor resolved by the variable handler.
+// By default, such variables are left as they are.
+//
+// The error is only returned once libucl has finished with the data, so the
+// rest of it has already been added to the parser's object, with the
+// variable left as it is. Use a new parser to discard it.
+func (p *Parser) SetStrictVariables(strict bool) {
+	p.variableState().strict = strict
+}
+
+// variableState returns the state of the parser's variable handler,
+// registering the handler with libucl the first time.
+func (p *Parser) variableState() *variableState {
+	defer runtime.KeepAlive(p)
+	if p.variables != nil {
+		return p.variables
+	}
+
+	p.variables = &variableState{
+		parser: p.parser,
+		values: make(map[string]variableValue),
+	}
+
+	variableHandlersLock.Lock()
+	if variableHandlers == nil {
+		variableHandlers = make(map[int]*variableState)
+	}
+	for variableHandlers[variableHandlersIdx] != nil {
+		variableHandlersIdx++
+	}
+	p.variablesIdx = variableHandlersIdx
+	variableHandlers[p.variablesIdx] = p.variables
+	variableHandlersIdx++
+	variableHandlersLock.Unlock()
+
+	C.ucl_parser_set_variables_handler(
+		p.parser,
+		C._go_variable_handler_func(),
+		C._go_macro_index(C.int(p.variablesIdx)))
+
+	return p.variables
+}
+
+// variableErr returns the error for an unknown variable found while adding
+// data to the parser in strict mode, if there was one. source and data are
+// what was being parsed, if known.
+func (p *Parser) variableErr(source string, data []byte) error {
+	if p.variables == nil || p.variables.err == nil {
+		return nil
+	}
+
+	e := p.variables.err
+	p.variables.err = nil
+	if e.Source == "" {
+		e.Source = source
+		e.data = data
+
+		// As in parseError, libucl's current file only means something
+		// when parsing a file, where it may be one that file included.
+		if file := p.variables.errFile; source != "" && file != "" && file != source {
+			e.Source = file
+			e.data = nil
+		}
+	}
+	return e
+}
+
+// closeVariables unregisters the parser's variable handler.
+func (p *Parser) closeVariables() {
+	if p.variables == nil {
+		return
+	}
+
+	variableHandlersLock.Lock()
+	delete(variableHandlers, p.variablesIdx)
+	variableHandlersLock.Unlock()
+	p.variables = nil
+}
+
+//export go_variable_call
+func go_variable_call(id C.int, data *C.uchar, n C.size_t, replace **C.uchar, replaceLen *C.size_t, needFree *C.bool) C.bool {
+	variableHandlersLock.Lock()
+	v := variableHandlers[int(id)]
+	variableHandlersLock.Unlock()
+
+	if v == nil {
+		return false
+	}
+
+	name := C.GoStringN(C._go_uchar_to_char(data), C.int(n))
+	result, ok := v.values[name]
+	if !ok {
+		if v.handler != nil {
+			result.value, result.ok = v.handler(name)
+		}
+		v.values[name] = result
+	}
+
+	if !result.ok {
+		if v.strict && v.err == nil {
+			v.err = v.newError(name)
+		}
+		return false
+	}
+
+	// libucl frees the value when it's done with it
+	*replace = (*C.uchar)(C.CBytes([]byte(result.value)))
+	*replaceLen = C.size_t(len(result.value))
+	*needFree = true
+	return true
+}
+
+// newError builds the error for an unknown variable, at the position the
+// parser has reached.
+func (v *variableState) newError(name string) *ParseError {
+	e := &ParseError{
+		Line:    int(C.ucl_parser_get_linenum(v.parser)),
+		Column:  int(C.ucl_parser_get_column(v.parser)),
+		Code:    ParseErrorSyntax,
+		Message: fmt.Sprintf("unknown variable: %s", name),
+	}
+	// Otherwise variableErr fills in what was being added
+	v.errFile = ""
+	if f := v.include; f != nil {
+		e.Source = f.Name
+		e.data = f.Data
+	} else if cur := C.ucl_parser_get_cur_file(v.parser); cur != nil {
+		v.errFile = C.GoString(C._go_uchar_to_char(cur))
+	}
+	return e
+}
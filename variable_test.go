@@ -0,0 +1,128 @@
+package libucl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParserSetVariableHandler(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.RegisterVariable("REGISTERED", "first")
+
+	calls := make(map[string]int)
+	p.SetVariableHandler(func(name string) (string, bool) {
+		calls[name]++
+		switch name {
+		case "HOST":
+			return "example.com", true
+		case "REGISTERED":
+			return "second", true
+		}
+		return "", false
+	})
+
+	err := p.AddString(`
+	url = "http://${HOST}:${PORT}/";
+	again = "${HOST}";
+	registered = "${REGISTERED}";
+	`)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	obj := p.Object()
+	defer obj.Close()
+
+	var result map[string]string
+	if err := obj.Decode(&result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"url":        "http://example.com:${PORT}/",
+		"again":      "example.com",
+		"registered": "first",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if calls["HOST"] != 1 || calls["REGISTERED"] != 0 {
+		t.Fatalf("bad: %#v", calls)
+	}
+}
+
+func TestParserSetStrictVariables(t *testing.T) {
+	p := NewParser(0)
+	defer p.Close()
+	p.SetStrictVariables(true)
+	p.SetVariableHandler(func(name string) (string, bool) {
+		return "value", name == "KNOWN"
+	})
+
+	if err := p.AddString(`known = "${KNOWN}";`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := p.AddString("ok = 1;\nport = \"${PORT}\";\n")
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Message != "unknown variable: PORT" || perr.Line == 0 {
+		t.Fatalf("bad: %#v", perr)
+	}
+
+	// The parser can carry on with more data
+	if err := p.AddString(`other = 2;`); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestParserSetStrictVariables_source(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.conf")
+	if err := ioutil.WriteFile(path, []byte(`name = "foo";`), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	p := NewParser(0)
+	defer p.Close()
+	p.SetStrictVariables(true)
+	p.SetIncludeFS(fstest.MapFS{
+		"inc.conf": {Data: []byte("ok = 1;\nport = \"${PORT}\";\n")},
+	})
+
+	if err := p.AddFile(path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Not the file that was added before
+	err := p.AddString(`host = "${HOST}";`)
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "" {
+		t.Fatalf("bad: %#v", perr.Source)
+	}
+
+	err = p.AddString(`.include "inc.conf"`)
+	perr, ok = err.(*ParseError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if perr.Source != "inc.conf" || perr.Line != 2 {
+		t.Fatalf("bad: %#v", perr)
+	}
+
+	// The data was still added
+	obj := p.Object()
+	defer obj.Close()
+	if v, _ := obj.LookupString("host", ""); v != "${HOST}" {
+		t.Fatalf("bad: %#v", v)
+	}
+}
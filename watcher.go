@@ -0,0 +1,347 @@
+package libucl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// DefaultWatchDebounce is how long a Watcher waits for changes to settle
+// before reloading, if WatcherConfig.Debounce isn't set.
+const DefaultWatchDebounce = 100 * time.Millisecond
+
+// WatcherConfig configures a Watcher.
+type WatcherConfig struct {
+	// Path is the main configuration file.
+	Path string
+
+	// Result is a pointer to the value to decode the configuration into
+	// when the Watcher is created. Later reloads decode into new values of
+	// the same type, so Result itself is never modified again.
+	Result interface{}
+
+	// Flags are passed to NewParser for each reload.
+	Flags ParserFlag
+
+	// Setup, if set, is called with each new parser before the file is
+	// added, for example to register variables or macros.
+	Setup func(*Parser) error
+
+	// Debounce is how long to wait after the last change before reloading.
+	Debounce time.Duration
+
+	// OnChange, if set, is called with every reload instead of sending it
+	// on the Updates channel. It must not close the Watcher.
+	OnChange func(WatchUpdate)
+}
+
+// WatchUpdate is the result of reloading a watched configuration.
+type WatchUpdate struct {
+	// Value is a pointer to the newly decoded configuration, of the same
+	// type as WatcherConfig.Result, or nil if it couldn't be reloaded.
+	Value interface{}
+
+	// Err is the reason the configuration couldn't be reloaded. It can also
+	// be set along with Value if some of the new files can't be watched.
+	Err error
+}
+
+// Watcher reloads a configuration file whenever it or any of the files it
+// includes change.
+//
+// Included files are read by the Watcher itself so it can keep track of
+// them, with relative paths resolved against the directory of the file that
+// includes them. Glob patterns are watched as a whole, so adding a file to a
+// conf.d directory also triggers a reload. Files that are symbolic links are
+// followed, and replacing a link, as Kubernetes does when a mounted
+// ConfigMap changes, also triggers a reload.
+type Watcher struct {
+	config   WatcherConfig
+	path     string
+	watcher  *fsnotify.Watcher
+	updates  chan WatchUpdate
+	done     chan struct{}
+	closeErr error
+
+	// The files and glob patterns that make up the configuration, and the
+	// directories being watched for them.
+	patterns map[string]struct{}
+	dirs     map[string]struct{}
+
+	lock      sync.Mutex
+	value     interface{}
+	closeOnce sync.Once
+}
+
+// NewWatcher loads the configuration and starts watching it for changes. It
+// returns an error if the configuration can't be loaded the first time.
+//
+// The Watcher must be closed when you're done using it.
+func NewWatcher(config *WatcherConfig) (*Watcher, error) {
+	result := reflect.ValueOf(config.Result)
+	if result.Kind() != reflect.Ptr || result.IsNil() {
+		return nil, errors.New("result must be a non-nil pointer")
+	}
+
+	path, err := filepath.Abs(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Watcher{
+		config:   *config,
+		path:     path,
+		watcher:  fsw,
+		updates:  make(chan WatchUpdate, 1),
+		done:     make(chan struct{}),
+		patterns: make(map[string]struct{}),
+		dirs:     make(map[string]struct{}),
+		value:    config.Result,
+	}
+	if w.config.Debounce <= 0 {
+		w.config.Debounce = DefaultWatchDebounce
+	}
+
+	patterns, err := w.load(config.Result)
+	if err == nil {
+		err = w.watch(patterns, true)
+	}
+	if err != nil {
+		fsw.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// Updates returns the channel on which reloads are delivered, unless
+// OnChange was set. If an update isn't received before the next one, only
+// the newest is kept. The channel is closed when the Watcher is closed.
+func (w *Watcher) Updates() <-chan WatchUpdate {
+	return w.updates
+}
+
+// Value returns a pointer to the last configuration that was successfully
+// loaded.
+func (w *Watcher) Value() interface{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.value
+}
+
+// Close stops watching for changes.
+func (w *Watcher) Close() error {
+	w.closeOnce.Do(func() {
+		w.closeErr = w.watcher.Close()
+		<-w.done
+	})
+	return w.closeErr
+}
+
+func (w *Watcher) run() {
+	defer close(w.done)
+	defer close(w.updates)
+
+	timer := time.NewTimer(w.config.Debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !w.matches(event.Name) {
+				continue
+			}
+
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(w.config.Debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.deliver(WatchUpdate{Err: err})
+		case <-timer.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	v := reflect.New(reflect.TypeOf(w.config.Result).Elem()).Interface()
+
+	patterns, err := w.load(v)
+	watchErr := w.watch(patterns, err == nil)
+	if err != nil {
+		w.deliver(WatchUpdate{Err: err})
+		return
+	}
+
+	w.lock.Lock()
+	w.value = v
+	w.lock.Unlock()
+
+	w.deliver(WatchUpdate{Value: v, Err: watchErr})
+}
+
+func (w *Watcher) deliver(update WatchUpdate) {
+	if w.config.OnChange != nil {
+		w.config.OnChange(update)
+		return
+	}
+
+	// Replace an update that hasn't been received yet, rather than blocking
+	for {
+		select {
+		case w.updates <- update:
+			return
+		default:
+		}
+
+		select {
+		case <-w.updates:
+		default:
+		}
+	}
+}
+
+// load parses the configuration into v and returns the files and patterns
+// that were read, even if it fails.
+func (w *Watcher) load(v interface{}) (map[string]struct{}, error) {
+	patterns := map[string]struct{}{w.path: {}}
+
+	p := NewParser(w.config.Flags)
+	defer p.Close()
+
+	p.SetIncludeHandler(func(pattern string, glob bool) ([]IncludeFile, error) {
+		if !filepath.IsAbs(pattern) {
+			dir := filepath.Dir(w.path)
+			if p.includeFile != nil {
+				dir = filepath.Dir(p.includeFile.Name)
+			}
+			pattern = filepath.Join(dir, pattern)
+		}
+		patterns[pattern] = struct{}{}
+
+		files, err := includeFS(os.DirFS("/"), pattern, glob)
+		for i := range files {
+			files[i].Name = "/" + files[i].Name
+			addLinkTarget(patterns, files[i].Name)
+		}
+		return files, err
+	})
+
+	if w.config.Setup != nil {
+		if err := w.config.Setup(p); err != nil {
+			return patterns, err
+		}
+	}
+
+	addLinkTarget(patterns, w.path)
+	if err := p.AddFile(w.path); err != nil {
+		return patterns, err
+	}
+
+	obj := p.Object()
+	if obj == nil {
+		return patterns, fmt.Errorf("%s: empty configuration", w.path)
+	}
+	defer obj.Close()
+
+	return patterns, obj.Decode(v)
+}
+
+// watch starts watching the directories of the given patterns. If replace
+// is true, patterns and directories that aren't needed anymore are dropped.
+func (w *Watcher) watch(patterns map[string]struct{}, replace bool) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if replace {
+		w.patterns = patterns
+	} else {
+		for pattern := range patterns {
+			w.patterns[pattern] = struct{}{}
+		}
+	}
+
+	// Directories are watched rather than files, so that files which are
+	// replaced by renaming or are created later are noticed.
+	dirs := make(map[string]struct{})
+	var err error
+	for pattern := range w.patterns {
+		dir := filepath.Dir(pattern)
+		dirs[dir] = struct{}{}
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+
+		if addErr := w.watcher.Add(dir); addErr != nil {
+			// Missing directories of optional includes are fine
+			if !errors.Is(addErr, os.ErrNotExist) && err == nil {
+				err = addErr
+			}
+			delete(dirs, dir)
+		}
+	}
+
+	for dir := range w.dirs {
+		if _, ok := dirs[dir]; ok {
+			continue
+		}
+
+		if replace {
+			w.watcher.Remove(dir)
+		} else {
+			dirs[dir] = struct{}{}
+		}
+	}
+	w.dirs = dirs
+
+	return err
+}
+
+// matches returns whether the file name is part of the configuration. Any
+// symbolic link in a watched directory is, as it may be one that a file of
+// the configuration is reached through.
+func (w *Watcher) matches(name string) bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for pattern := range w.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	info, err := os.Lstat(name)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// addLinkTarget adds the file that path refers to, if it is reached through
+// symbolic links, so that its directory is watched as well.
+func addLinkTarget(patterns map[string]struct{}, path string) {
+	if target, err := filepath.EvalSymlinks(path); err == nil && target != path {
+		patterns[target] = struct{}{}
+	}
+}
@@ -0,0 +1,223 @@
+package libucl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testWatchConfig struct {
+	Name string `libucl:"name"`
+	Port int    `libucl:"port"`
+}
+
+func testWatchDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, contents := range files {
+		testWriteFile(t, filepath.Join(dir, name), contents)
+	}
+	return dir
+}
+
+// testWriteFile replaces the file by renaming a new one over it, so that a
+// watcher never sees it half written.
+func testWriteFile(t *testing.T, path, contents string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(contents), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+// testWatchUpdate waits for an update that ok accepts. Earlier updates, such
+// as those for events that arrived before the change being waited for, are
+// skipped.
+func testWatchUpdate(t *testing.T, updates <-chan WatchUpdate, ok func(WatchUpdate) bool) WatchUpdate {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case update := <-updates:
+			if ok(update) {
+				return update
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for update")
+			return WatchUpdate{}
+		}
+	}
+}
+
+// testWatchPort accepts an update with the given port.
+func testWatchPort(port int) func(WatchUpdate) bool {
+	return func(u WatchUpdate) bool {
+		v, ok := u.Value.(*testWatchConfig)
+		return ok && v.Port == port
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"app.conf":          `name = "foo"; .include(glob=true) "conf.d/*.conf"`,
+		"conf.d/port.conf":  `port = 80;`,
+		"conf.d/other.conf": `other = true;`,
+	})
+
+	var result testWatchConfig
+	w, err := NewWatcher(&WatcherConfig{
+		Path:     filepath.Join(dir, "app.conf"),
+		Result:   &result,
+		Debounce: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer w.Close()
+
+	if result.Name != "foo" || result.Port != 80 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// Changing an included file reloads
+	testWriteFile(t, filepath.Join(dir, "conf.d/port.conf"), `port = 8080;`)
+	update := testWatchUpdate(t, w.Updates(), testWatchPort(8080))
+	if update.Err != nil {
+		t.Fatalf("err: %s", update.Err)
+	}
+	if v := update.Value.(*testWatchConfig); v.Name != "foo" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	// A broken file keeps the last good configuration
+	testWriteFile(t, filepath.Join(dir, "app.conf"), `name = {`)
+	update = testWatchUpdate(t, w.Updates(), func(u WatchUpdate) bool {
+		return u.Err != nil
+	})
+	if update.Value != nil {
+		t.Fatalf("bad: %#v", update)
+	}
+	if v := w.Value().(*testWatchConfig); v.Port != 8080 {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if result.Port != 80 {
+		t.Fatalf("result should not change: %#v", result)
+	}
+}
+
+func TestWatcher_onChange(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"app.conf": `name = "foo";`,
+	})
+
+	updates := make(chan WatchUpdate, 10)
+	w, err := NewWatcher(&WatcherConfig{
+		Path:     filepath.Join(dir, "app.conf"),
+		Result:   &testWatchConfig{},
+		Debounce: 10 * time.Millisecond,
+		OnChange: func(u WatchUpdate) { updates <- u },
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer w.Close()
+
+	testWriteFile(t, filepath.Join(dir, "app.conf"), `name = "bar";`)
+	testWatchUpdate(t, updates, func(u WatchUpdate) bool {
+		v, ok := u.Value.(*testWatchConfig)
+		return ok && v.Name == "bar"
+	})
+}
+
+func TestWatcher_nestedInclude(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"app.conf":      `name = "foo"; .include "sub/main.conf"`,
+		"sub/main.conf": `.include "port.conf"`,
+		"sub/port.conf": `port = 80;`,
+		"port.conf":     `port = 1;`,
+	})
+
+	var result testWatchConfig
+	w, err := NewWatcher(&WatcherConfig{
+		Path:     filepath.Join(dir, "app.conf"),
+		Result:   &result,
+		Debounce: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer w.Close()
+
+	// Relative to the including file, not the main one
+	if result.Port != 80 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	testWriteFile(t, filepath.Join(dir, "sub/port.conf"), `port = 8080;`)
+	testWatchUpdate(t, w.Updates(), testWatchPort(8080))
+}
+
+func TestWatcher_symlinkSwap(t *testing.T) {
+	// Laid out like a Kubernetes ConfigMap volume
+	dir := testWatchDir(t, map[string]string{
+		"..v1/app.conf": `port = 80;`,
+		"..v2/app.conf": `port = 8080;`,
+	})
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Symlink("..data/app.conf", filepath.Join(dir, "app.conf")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var result testWatchConfig
+	w, err := NewWatcher(&WatcherConfig{
+		Path:     filepath.Join(dir, "app.conf"),
+		Result:   &result,
+		Debounce: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer w.Close()
+
+	if result.Port != 80 {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	// The link is replaced by renaming a new one over it
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink("..v2", tmp); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	testWatchUpdate(t, w.Updates(), testWatchPort(8080))
+}
+
+func TestNewWatcher_invalid(t *testing.T) {
+	dir := testWatchDir(t, map[string]string{
+		"app.conf": `name = {`,
+	})
+
+	if _, err := NewWatcher(&WatcherConfig{
+		Path:   filepath.Join(dir, "app.conf"),
+		Result: &testWatchConfig{},
+	}); err == nil {
+		t.Fatal("should error")
+	}
+
+	if _, err := NewWatcher(&WatcherConfig{
+		Path:   filepath.Join(dir, "app.conf"),
+		Result: testWatchConfig{},
+	}); err == nil {
+		t.Fatal("should error")
+	}
+}